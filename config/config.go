@@ -0,0 +1,400 @@
+package config
+
+import (
+	"database/sql"
+	"dytest/db"
+	"dytest/file"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//数据库配置
+type DBConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	//密码文件, 未配置密码时读取文件内容作为密码
+	PasswordFile string     `yaml:"passwordFile"`
+	Database     string     `yaml:"database"`
+	Pool         PoolConfig `yaml:"pool"`
+}
+
+//连接池配置, 0 表示使用 database/sql 的默认值
+type PoolConfig struct {
+	MaxOpenConns    int           `yaml:"maxOpenConns"`
+	MaxIdleConns    int           `yaml:"maxIdleConns"`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+}
+
+//检索配置
+type QueryConfig struct {
+	BatchSize     int           `yaml:"batchSize"`
+	BatchParallel int           `yaml:"batchParallel"`
+	Timeout       time.Duration `yaml:"timeout"`
+	QueryTimeout  time.Duration `yaml:"queryTimeout"`
+}
+
+//人体抓拍宽高小于阈值时判定为宽高不满足要求
+type Thresholds struct {
+	MinPersonWidth  int `yaml:"minPersonWidth"`
+	MinPersonHeight int `yaml:"minPersonHeight"`
+}
+
+//结果文件格式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatHTML = "html"
+)
+
+//逐条抓拍结果表格式
+const (
+	TableCSV  = "csv"
+	TableTSV  = "tsv"
+	TableNone = "none"
+)
+
+//输出配置, Dir 为空时输出到数据目录下的 result 目录
+type OutputConfig struct {
+	Dir string `yaml:"dir"`
+	//结果文件格式, 多个格式以逗号分隔
+	Format string `yaml:"format"`
+	//与结果文件同目录输出的逐条抓拍结果表
+	Table string `yaml:"table"`
+	//html 报告中显示抓拍缩略图
+	Thumbnails bool `yaml:"thumbnails"`
+	//图片地址前缀, 数据库中的图片地址为相对路径时拼接在前面
+	ImageBaseUrl string `yaml:"imageBaseUrl"`
+	//输出本次运行所有文件结果的 Excel 工作簿
+	Xlsx bool `yaml:"xlsx"`
+}
+
+//S3 协议访问任务档案, 未配置 Endpoint 时直接读取本地 S3Root 目录
+type S3Config struct {
+	//如 http://152.9.11.99:9000
+	Endpoint string `yaml:"endpoint"`
+	Bucket   string `yaml:"bucket"`
+	//任务目录所在前缀, 如 pvid/person
+	Prefix    string `yaml:"prefix"`
+	Region    string `yaml:"region"`
+	AccessKey string `yaml:"accessKey"`
+	SecretKey string `yaml:"secretKey"`
+}
+
+//一个现场或环境的配置
+type Profile struct {
+	Vertica DBConfig `yaml:"vertica"`
+	PG      DBConfig `yaml:"pg"`
+	S3Root  string   `yaml:"s3Root"`
+	S3      S3Config `yaml:"s3"`
+	DataDir string   `yaml:"dataDir"`
+	Date    string   `yaml:"date"`
+	//按该时区计算任务的创建日期, 在线检索及离线快照使用同一时区
+	TimeZone   string       `yaml:"timeZone"`
+	Query      QueryConfig  `yaml:"query"`
+	Thresholds Thresholds   `yaml:"thresholds"`
+	Output     OutputConfig `yaml:"output"`
+	Tables     db.Schema    `yaml:"tables"`
+	//pgpass 格式的口令文件, 默认为 $PGPASSFILE 或 ~/.pgpass
+	PassFile string `yaml:"passFile"`
+	//同时分析的文件数量
+	Workers int `yaml:"workers"`
+	//输出分析进度的间隔, 0 表示不输出
+	Progress time.Duration `yaml:"progress"`
+	//档案类别优先级, 抓拍命中多个档案时以优先级最高的作为丢弃原因
+	Precedence []string `yaml:"precedence"`
+	//档案文件无法读取或解析时终止分析, 否则记录到结果中继续
+	StrictArchive bool `yaml:"strictArchive"`
+}
+
+//配置文件, Profile 为未指定时使用的默认配置名, 各配置保留原始节点以便覆盖到默认配置上
+type File struct {
+	Profile  string               `yaml:"profile"`
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+}
+
+//默认配置
+func Default() Profile {
+	return Profile{
+		Vertica:  DBConfig{Host: "127.0.0.1", Port: 5433, User: "dbadmin", Database: "viid"},
+		PG:       DBConfig{Host: "127.0.0.1", Port: 31583, User: "pgsql", Database: "pvid"},
+		S3Root:   "/home/minio/data/pvid/person",
+		DataDir:  "data",
+		TimeZone: "Asia/Shanghai",
+		Query: QueryConfig{
+			BatchSize:     db.DefaultBatchSize,
+			BatchParallel: 1,
+			QueryTimeout:  10 * time.Minute,
+		},
+		Thresholds: Thresholds{MinPersonWidth: 60, MinPersonHeight: 150},
+		Output:     OutputConfig{Format: FormatText, Table: TableCSV},
+		Tables:     db.DefaultSchema(),
+		Workers:    1,
+		Progress:   5 * time.Second,
+		Precedence: file.DefaultPrecedence(),
+	}
+}
+
+//加载配置文件中的指定配置, 未配置的项使用默认值, path 为空时返回默认配置
+func Load(path string, profile string) (Profile, error) {
+	p := Default()
+	if path == "" {
+		return p, nil
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	var f File
+	if err := yaml.Unmarshal(bs, &f); err != nil {
+		return p, fmt.Errorf("parse config %s err: %v", path, err)
+	}
+	if profile == "" {
+		profile = f.Profile
+	}
+	node, ok := f.Profiles[profile]
+	if !ok {
+		return p, fmt.Errorf("profile %q not found in %s", profile, path)
+	}
+	return p.decode(&node)
+}
+
+//将配置节点解码到 p 上, 节点中出现的项即使为零值也覆盖默认值, 未出现的项保持不变
+func (p Profile) decode(node *yaml.Node) (Profile, error) {
+	defaults := p.Tables
+	//表映射按表及列合并, 不整体替换
+	p.Tables = nil
+	if err := node.Decode(&p); err != nil {
+		return p, err
+	}
+	tables, err := defaults.Merge(p.Tables)
+	if err != nil {
+		return p, err
+	}
+	p.Tables = tables
+	return p, nil
+}
+
+//校验配置项取值
+func (p Profile) Validate() error {
+	for _, c := range p.Precedence {
+		if !file.IsCategory(c) {
+			return fmt.Errorf("unknown archive category in precedence: %s", c)
+		}
+	}
+	for _, f := range strings.Split(p.Output.Format, ",") {
+		switch strings.TrimSpace(f) {
+		case FormatText, FormatJSON, FormatHTML:
+		default:
+			return fmt.Errorf("unknown output format: %s", f)
+		}
+	}
+	if _, err := p.Location(); err != nil {
+		return err
+	}
+	switch p.Output.Table {
+	case TableCSV, TableTSV, TableNone:
+	default:
+		return fmt.Errorf("unknown output table format: %s", p.Output.Table)
+	}
+	if p.S3.Endpoint != "" {
+		if _, err := url.Parse(p.S3.Endpoint); err != nil {
+			return fmt.Errorf("invalid s3 endpoint: %v", err)
+		}
+		if p.S3.Bucket == "" {
+			return fmt.Errorf("s3 bucket is required when s3 endpoint is set")
+		}
+	}
+	return nil
+}
+
+//任务日期所在的时区
+func (p Profile) Location() (*time.Location, error) {
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %v", p.TimeZone, err)
+	}
+	return loc, nil
+}
+
+//环境变量前缀, 如 DYTEST_VERTICA_HOST
+const EnvPrefix = "DYTEST_"
+
+//以环境变量覆盖配置, getenv 通常为 os.Getenv
+func (p *Profile) ApplyEnv(getenv func(string) string) error {
+	strs := map[string]*string{
+		"VERTICA_HOST":          &p.Vertica.Host,
+		"VERTICA_USER":          &p.Vertica.User,
+		"VERTICA_PASSWORD":      &p.Vertica.Password,
+		"VERTICA_PASSWORD_FILE": &p.Vertica.PasswordFile,
+		"VERTICA_DATABASE":      &p.Vertica.Database,
+		"PG_HOST":               &p.PG.Host,
+		"PG_USER":               &p.PG.User,
+		"PG_PASSWORD":           &p.PG.Password,
+		"PG_PASSWORD_FILE":      &p.PG.PasswordFile,
+		"PG_DATABASE":           &p.PG.Database,
+		"S3_ROOT":               &p.S3Root,
+		"S3_ENDPOINT":           &p.S3.Endpoint,
+		"S3_BUCKET":             &p.S3.Bucket,
+		"S3_PREFIX":             &p.S3.Prefix,
+		"S3_REGION":             &p.S3.Region,
+		"S3_ACCESS_KEY":         &p.S3.AccessKey,
+		"S3_SECRET_KEY":         &p.S3.SecretKey,
+		"DATA_DIR":              &p.DataDir,
+		"DATE":                  &p.Date,
+		"TIME_ZONE":             &p.TimeZone,
+		"OUTPUT_DIR":            &p.Output.Dir,
+		"OUTPUT_FORMAT":         &p.Output.Format,
+		"OUTPUT_TABLE":          &p.Output.Table,
+		"IMAGE_BASE_URL":        &p.Output.ImageBaseUrl,
+		"PASS_FILE":             &p.PassFile,
+	}
+	for name, field := range strs {
+		if v := getenv(EnvPrefix + name); v != "" {
+			*field = v
+		}
+	}
+	ints := map[string]*int{
+		"VERTICA_PORT":           &p.Vertica.Port,
+		"PG_PORT":                &p.PG.Port,
+		"BATCH_SIZE":             &p.Query.BatchSize,
+		"BATCH_PARALLEL":         &p.Query.BatchParallel,
+		"MIN_PERSON_WIDTH":       &p.Thresholds.MinPersonWidth,
+		"MIN_PERSON_HEIGHT":      &p.Thresholds.MinPersonHeight,
+		"WORKERS":                &p.Workers,
+		"VERTICA_MAX_OPEN_CONNS": &p.Vertica.Pool.MaxOpenConns,
+		"VERTICA_MAX_IDLE_CONNS": &p.Vertica.Pool.MaxIdleConns,
+		"PG_MAX_OPEN_CONNS":      &p.PG.Pool.MaxOpenConns,
+		"PG_MAX_IDLE_CONNS":      &p.PG.Pool.MaxIdleConns,
+	}
+	for name, field := range ints {
+		if v := getenv(EnvPrefix + name); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("env %s%s: %v", EnvPrefix, name, err)
+			}
+			*field = i
+		}
+	}
+	if v := getenv(EnvPrefix + "PRECEDENCE"); v != "" {
+		p.Precedence = SplitList(v)
+	}
+	bools := map[string]*bool{
+		"STRICT_ARCHIVE":    &p.StrictArchive,
+		"OUTPUT_THUMBNAILS": &p.Output.Thumbnails,
+		"OUTPUT_XLSX":       &p.Output.Xlsx,
+	}
+	for name, field := range bools {
+		if v := getenv(EnvPrefix + name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("env %s%s: %v", EnvPrefix, name, err)
+			}
+			*field = b
+		}
+	}
+	durations := map[string]*time.Duration{
+		"TIMEOUT":                    &p.Query.Timeout,
+		"QUERY_TIMEOUT":              &p.Query.QueryTimeout,
+		"PROGRESS":                   &p.Progress,
+		"VERTICA_CONN_MAX_IDLE_TIME": &p.Vertica.Pool.ConnMaxIdleTime,
+		"PG_CONN_MAX_IDLE_TIME":      &p.PG.Pool.ConnMaxIdleTime,
+	}
+	for name, field := range durations {
+		if v := getenv(EnvPrefix + name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("env %s%s: %v", EnvPrefix, name, err)
+			}
+			*field = d
+		}
+	}
+	return nil
+}
+
+//用户名和密码经过转义, 可包含特殊字符
+func (d DBConfig) url(scheme string) string {
+	u := url.URL{
+		Scheme:   scheme,
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     net.JoinHostPort(d.Host, strconv.Itoa(d.Port)),
+		Path:     "/" + d.Database,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
+//设置连接池参数
+func (pc PoolConfig) Apply(conn *sql.DB) {
+	if pc.MaxOpenConns != 0 {
+		conn.SetMaxOpenConns(pc.MaxOpenConns)
+	}
+	if pc.MaxIdleConns != 0 {
+		conn.SetMaxIdleConns(pc.MaxIdleConns)
+	}
+	if pc.ConnMaxIdleTime != 0 {
+		conn.SetConnMaxIdleTime(pc.ConnMaxIdleTime)
+	}
+	if pc.ConnMaxLifetime != 0 {
+		conn.SetConnMaxLifetime(pc.ConnMaxLifetime)
+	}
+}
+
+//MPP 数据库连接串
+func (p Profile) VerticaDSN() string {
+	return p.Vertica.url("vertica")
+}
+
+//PG 数据库连接串
+func (p Profile) PGDSN() string {
+	return p.PG.url("postgres")
+}
+
+const mask = "******"
+
+//输出生效的配置, 密码以掩码代替
+func (p Profile) Show() ([]byte, error) {
+	if p.Vertica.Password != "" {
+		p.Vertica.Password = mask
+	}
+	if p.PG.Password != "" {
+		p.PG.Password = mask
+	}
+	if p.S3.SecretKey != "" {
+		p.S3.SecretKey = mask
+	}
+	return yaml.Marshal(p)
+}
+
+//任务档案的存储, 配置了 S3 Endpoint 时通过 S3 协议读取
+func (p Profile) Storage() file.Storage {
+	if p.S3.Endpoint == "" {
+		return file.LocalStorage{Root: p.S3Root}
+	}
+	return file.S3Storage{
+		Endpoint:  p.S3.Endpoint,
+		Bucket:    p.S3.Bucket,
+		Prefix:    p.S3.Prefix,
+		Region:    p.S3.Region,
+		AccessKey: p.S3.AccessKey,
+		SecretKey: p.S3.SecretKey,
+	}
+}
+
+//按逗号分隔列表, 去除每项首尾的空白
+func SplitList(v string) []string {
+	items := strings.Split(v, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
+}
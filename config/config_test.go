@@ -0,0 +1,66 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadZeroValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yml := `
+profile: site-a
+profiles:
+  site-a:
+    workers: 0
+    progress: 0s
+    query:
+      batchParallel: 0
+      queryTimeout: 0s
+    thresholds:
+      minPersonWidth: 0
+    tables:
+      personstructured_a050300:
+        columns:
+          linkfacepersonid: link_face_person_id
+`
+	if err := ioutil.WriteFile(path, []byte(yml), 0666); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := Default()
+	if p.Workers != 0 || p.Progress != 0 || p.Query.BatchParallel != 0 || p.Query.QueryTimeout != 0 || p.Thresholds.MinPersonWidth != 0 {
+		t.Errorf("profile = %+v, want explicit zero values kept", p)
+	}
+	//未配置的项保持默认值
+	if p.Thresholds.MinPersonHeight != def.Thresholds.MinPersonHeight || p.Query.BatchSize != def.Query.BatchSize ||
+		p.Vertica.Host != def.Vertica.Host {
+		t.Errorf("profile = %+v, want defaults for absent keys", p)
+	}
+	if got := p.Tables.Col("personstructured_a050300", "linkfacepersonid"); got != "link_face_person_id" {
+		t.Errorf("mapped column = %s", got)
+	}
+	if got := p.Tables.Col("personstructured_a050300", "personid"); got != "personid" {
+		t.Errorf("default column = %s", got)
+	}
+	if p.Tables.Table("people_track") != def.Tables.Table("people_track") {
+		t.Errorf("default table name lost: %s", p.Tables.Table("people_track"))
+	}
+	if d := Default(); d.Tables.Col("personstructured_a050300", "linkfacepersonid") != "linkfacepersonid" {
+		t.Error("Load modified the default schema")
+	}
+}
+
+func TestLoadUnknownColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yml := "profiles:\n  default:\n    tables:\n      people_track:\n        columns:\n          nope: x\n"
+	if err := ioutil.WriteFile(path, []byte(yml), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, "default"); err == nil {
+		t.Error("want error for unknown column")
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//读取密码的方式, 未配置密码时依次尝试: 密码文件, pgpass 格式的口令文件, 终端输入
+type Prompt func(label string) (string, error)
+
+//补全未配置的数据库密码, prompt 为空时不从终端读取
+func (p *Profile) ResolvePasswords(prompt Prompt) error {
+	passFile := p.PassFile
+	if passFile == "" {
+		passFile = defaultPassFile()
+	}
+	if err := p.Vertica.resolvePassword("vertica", passFile, prompt); err != nil {
+		return err
+	}
+	return p.PG.resolvePassword("pg", passFile, prompt)
+}
+
+func (d *DBConfig) resolvePassword(label string, passFile string, prompt Prompt) error {
+	if d.Password != "" {
+		return nil
+	}
+	if d.PasswordFile != "" {
+		bs, err := ioutil.ReadFile(d.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("read %s password file err: %v", label, err)
+		}
+		d.Password = strings.TrimRight(string(bs), "\r\n")
+		return nil
+	}
+	if passFile != "" {
+		password, err := lookupPassFile(passFile, *d)
+		if err != nil {
+			return err
+		}
+		if password != "" {
+			d.Password = password
+			return nil
+		}
+	}
+	if prompt != nil {
+		password, err := prompt(fmt.Sprintf("%s password for %s@%s:%d: ", label, d.User, d.Host, d.Port))
+		if err != nil {
+			return err
+		}
+		d.Password = password
+	}
+	return nil
+}
+
+//默认口令文件: $PGPASSFILE 或 ~/.pgpass
+func defaultPassFile() string {
+	if f := os.Getenv("PGPASSFILE"); f != "" {
+		return f
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pgpass")
+}
+
+//按 hostname:port:database:username:password 格式查找密码, * 匹配任意值, 文件不存在时返回空串
+func lookupPassFile(path string, d DBConfig) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Mode().Perm()&0077 != 0 {
+		log.Println("ignore pass file with group or world access: ", path)
+		return "", nil
+	}
+	want := []string{d.Host, strconv.Itoa(d.Port), d.Database, d.User}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := splitPassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		matched := true
+		for i, w := range want {
+			if !fields[i].wildcard && fields[i].value != w {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return fields[4].value, nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+//口令文件中的一项, 只有未转义的单个 * 匹配任意值
+type passField struct {
+	value    string
+	wildcard bool
+}
+
+//按未转义的 : 切分, \: \* 和 \\ 为转义字符
+func splitPassLine(line string) []passField {
+	var fields []passField
+	var b strings.Builder
+	escaped, literal := false, false
+	field := func() passField {
+		f := passField{value: b.String(), wildcard: b.String() == "*" && !literal}
+		b.Reset()
+		literal = false
+		return f
+	}
+	for _, r := range line {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped, literal = false, true
+		case r == '\\':
+			escaped = true
+		case r == ':':
+			fields = append(fields, field())
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return append(fields, field())
+}
+
+//隐藏连接串中的密码, 用于日志和报告
+func RedactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "<invalid dsn>"
+	}
+	return u.Redacted()
+}
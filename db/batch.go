@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"dytest/utils"
+	"log"
+	"sync"
+	"time"
+)
+
+//默认每批检索的 id 数量
+const DefaultBatchSize = 1000
+
+//将 id 切分为多个批次
+func splitBatches(ids []string, size int) [][]string {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	batches := make([][]string, 0, (len(ids)+size-1)/size)
+	for start := 0; start < len(ids); start += size {
+		end := start + size
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batches = append(batches, ids[start:end])
+	}
+	return batches
+}
+
+//按批次执行检索并按批次顺序合并结果, Parallel 大于 1 时并发执行, 返回序号最小的批次错误.
+//id 先按首次出现的顺序去重, 与单个 in 子句一致, 重复的 id 不会在多个批次中重复返回
+func queryInBatches[T any](ctx context.Context, conn *DB, name string, ids []string,
+	query func(batch []string) ([]T, []RejectedRow, error)) ([]T, []RejectedRow, error) {
+	batches := splitBatches(utils.RemoveDeplicated(ids), conn.BatchSize)
+	results := make([][]T, len(batches))
+	rejects := make([][]RejectedRow, len(batches))
+	errs := make([]error, len(batches))
+	parallel := conn.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		sem <- struct{}{}
+		if err := ctx.Err(); err != nil {
+			<-sem
+			errs[i] = &QueryError{Query: name, Err: err}
+			break
+		}
+		wg.Add(1)
+		go func(i int, batch []string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			start := time.Now()
+			results[i], rejects[i], errs[i] = query(batch)
+			log.Printf("query %s batch %d/%d, ids: %d, rows: %d, rejected: %d, cost: %v\n",
+				name, i+1, len(batches), len(batch), len(results[i]), len(rejects[i]), time.Since(start))
+		}(i, batch)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	merged := make([]T, 0)
+	for _, r := range results {
+		merged = append(merged, r...)
+	}
+	rejected := make([]RejectedRow, 0)
+	for _, r := range rejects {
+		rejected = append(rejected, r...)
+	}
+	if len(rejected) > 0 {
+		log.Printf("query %s rejected rows: %d\n", name, len(rejected))
+	}
+	return merged, rejected, nil
+}
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestQueryInBatchesDedupe(t *testing.T) {
+	conn := &DB{BatchSize: 2}
+	ids := []string{"a", "b", "a", "c", "b"}
+	var batches [][]string
+	rows, _, err := queryInBatches(context.Background(), conn, "track", ids, func(batch []string) ([]string, []RejectedRow, error) {
+		batches = append(batches, batch)
+		return batch, nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"a", "b"}, {"c"}}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v, want %v", rows, want)
+	}
+}
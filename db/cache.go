@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+//同一次运行内缓存按日期的任务及设备聚档检索结果, 多个文件共用
+type CachedArchive struct {
+	repo ArchiveRepository
+
+	mu    sync.Mutex
+	tasks map[string]*cachedTasks
+	//设备是否为人体聚档设备
+	devices map[string]bool
+	//检索设备时的问题行, 无法对应到设备的行记在同次检索的所有设备上
+	deviceRejected map[string][]RejectedRow
+}
+
+//同一日期只检索一次, 检索期间其他调用方等待 done 关闭
+type cachedTasks struct {
+	done     chan struct{}
+	ids      []string
+	rejected []RejectedRow
+	err      error
+}
+
+func NewCachedArchive(repo ArchiveRepository) *CachedArchive {
+	return &CachedArchive{repo: repo, tasks: make(map[string]*cachedTasks), devices: make(map[string]bool),
+		deviceRejected: make(map[string][]RejectedRow)}
+}
+
+//不同日期的检索互不阻塞, 检索失败时不缓存, 之后的调用重新检索
+func (c *CachedArchive) QueryTask(ctx context.Context, date string) ([]string, []RejectedRow, error) {
+	c.mu.Lock()
+	t, ok := c.tasks[date]
+	if !ok {
+		t = &cachedTasks{done: make(chan struct{})}
+		c.tasks[date] = t
+	}
+	c.mu.Unlock()
+	if ok {
+		log.Println("person task cache hit for date: ", date)
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	} else {
+		t.ids, t.rejected, t.err = c.repo.QueryTask(ctx, date)
+		if t.err != nil {
+			c.mu.Lock()
+			delete(c.tasks, date)
+			c.mu.Unlock()
+		}
+		close(t.done)
+	}
+	if t.err != nil {
+		return nil, nil, t.err
+	}
+	return append([]string{}, t.ids...), t.rejected, nil
+}
+
+//只检索未缓存的设备, 检索结果中不存在的设备记为非人体聚档设备, 命中缓存时同样返回设备的问题行
+func (c *CachedArchive) QueryPersonArchiveIds(ctx context.Context, ids []string) ([]string, []RejectedRow, error) {
+	c.mu.Lock()
+	var missing []string
+	for _, id := range ids {
+		if _, ok := c.devices[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(missing) > 0 {
+		archived, rejected, err := c.repo.QueryPersonArchiveIds(ctx, missing)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.mu.Lock()
+		for _, id := range missing {
+			c.devices[id] = false
+			delete(c.deviceRejected, id)
+		}
+		for _, id := range archived {
+			c.devices[id] = true
+		}
+		for _, r := range rejected {
+			if _, ok := c.devices[r.SnapId]; ok && r.SnapId != "" {
+				c.deviceRejected[r.SnapId] = append(c.deviceRejected[r.SnapId], r)
+				continue
+			}
+			for _, id := range missing {
+				c.deviceRejected[id] = append(c.deviceRejected[id], r)
+			}
+		}
+		c.mu.Unlock()
+	}
+	log.Printf("person archive device cache: %d requested, %d queried\n", len(ids), len(missing))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make([]string, 0)
+	var rejected []RejectedRow
+	seen := make(map[RejectedRow]struct{})
+	for _, id := range ids {
+		if c.devices[id] {
+			result = append(result, id)
+		}
+		for _, r := range c.deviceRejected[id] {
+			if _, ok := seen[r]; !ok {
+				seen[r] = struct{}{}
+				rejected = append(rejected, r)
+			}
+		}
+	}
+	return result, rejected, nil
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//按日期阻塞的任务检索, 用于验证缓存的并发行为
+type blockingArchive struct {
+	Memory
+	release map[string]chan struct{}
+	queries int32
+	err     error
+}
+
+func (b *blockingArchive) QueryTask(ctx context.Context, date string) ([]string, []RejectedRow, error) {
+	atomic.AddInt32(&b.queries, 1)
+	if ch, ok := b.release[date]; ok {
+		<-ch
+	}
+	if b.err != nil {
+		return nil, nil, b.err
+	}
+	return []string{"task-" + date}, nil, nil
+}
+
+func TestCachedArchiveQueryTask(t *testing.T) {
+	slow := make(chan struct{})
+	b := &blockingArchive{release: map[string]chan struct{}{"2023-04-14": slow}}
+	c := NewCachedArchive(b)
+	ctx := context.Background()
+
+	results := make(chan []string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			ids, _, _ := c.QueryTask(ctx, "2023-04-14")
+			results <- ids
+		}()
+	}
+	//慢检索进行中时, 其他日期的检索不被阻塞
+	got := make(chan []string)
+	go func() {
+		ids, _, _ := c.QueryTask(ctx, "2023-04-15")
+		got <- ids
+	}()
+	select {
+	case ids := <-got:
+		if len(ids) != 1 || ids[0] != "task-2023-04-15" {
+			t.Errorf("tasks = %v", ids)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("query for another date blocked by a pending query")
+	}
+	close(slow)
+	for i := 0; i < 2; i++ {
+		if ids := <-results; len(ids) != 1 || ids[0] != "task-2023-04-14" {
+			t.Errorf("tasks = %v", ids)
+		}
+	}
+	if n := atomic.LoadInt32(&b.queries); n != 2 {
+		t.Errorf("queries = %d, want one per date", n)
+	}
+}
+
+func TestCachedArchiveQueryTaskError(t *testing.T) {
+	b := &blockingArchive{err: errors.New("connection reset")}
+	c := NewCachedArchive(b)
+	if _, _, err := c.QueryTask(context.Background(), "2023-04-15"); err == nil {
+		t.Fatal("want error")
+	}
+	b.err = nil
+	ids, _, err := c.QueryTask(context.Background(), "2023-04-15")
+	if err != nil || len(ids) != 1 {
+		t.Errorf("tasks = %v, %v, want retried query", ids, err)
+	}
+}
+
+//设备检索返回固定的问题行, 记录每次检索的设备
+type deviceArchive struct {
+	Memory
+	rejected []RejectedRow
+	queried  [][]string
+}
+
+func (d *deviceArchive) QueryPersonArchiveIds(ctx context.Context, ids []string) ([]string, []RejectedRow, error) {
+	d.queried = append(d.queried, ids)
+	return []string{"dev1"}, d.rejected, nil
+}
+
+func TestCachedArchiveDeviceRejected(t *testing.T) {
+	dev2 := RejectedRow{Query: "person archive device", SnapId: "dev2", Reason: "archive_type is NULL"}
+	unknown := RejectedRow{Query: "person archive device", Reason: "device_id is NULL"}
+	d := &deviceArchive{rejected: []RejectedRow{dev2, unknown}}
+	c := NewCachedArchive(d)
+	ctx := context.Background()
+	if _, rejected, err := c.QueryPersonArchiveIds(ctx, []string{"dev1", "dev2"}); err != nil ||
+		!reflect.DeepEqual(rejected, []RejectedRow{unknown, dev2}) {
+		t.Errorf("rejected = %v, %v", rejected, err)
+	}
+	//命中缓存的调用方同样得到设备的问题行
+	tests := []struct {
+		ids      []string
+		archived []string
+		rejected []RejectedRow
+	}{
+		{[]string{"dev1"}, []string{"dev1"}, []RejectedRow{unknown}},
+		{[]string{"dev2"}, []string{}, []RejectedRow{dev2, unknown}},
+		{[]string{"dev2", "dev1"}, []string{"dev1"}, []RejectedRow{dev2, unknown}},
+	}
+	for _, tt := range tests {
+		archived, rejected, err := c.QueryPersonArchiveIds(ctx, tt.ids)
+		if err != nil || !reflect.DeepEqual(archived, tt.archived) || !reflect.DeepEqual(rejected, tt.rejected) {
+			t.Errorf("QueryPersonArchiveIds(%v) = %v, %v, %v, want %v, %v", tt.ids, archived, rejected, err, tt.archived, tt.rejected)
+		}
+	}
+	if len(d.queried) != 1 {
+		t.Errorf("queried = %v, want devices queried once", d.queried)
+	}
+}
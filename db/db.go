@@ -1,10 +1,12 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
-	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type DriverName string
@@ -22,14 +24,13 @@ type Track struct {
 	TrackType   int
 }
 
+//轨迹表中 type 为空时的轨迹类型, 由调用方根据抓拍 id 判断人脸或人体
+const TrackTypeUnknown = -1
+
 func (t Track) String() string {
 	return fmt.Sprintf("%s,%s,%d", t.SnapId, t.PeopleId, t.TrackType)
 }
 
-func (t Track) TableName() string {
-	return "viid_facestatic.people_track"
-}
-
 type FaceInfo struct {
 	FaceId           string
 	DeviceId         string
@@ -55,129 +56,338 @@ type PersonInfo struct {
 	Height     int
 }
 
-func Connect(driver DriverName, dbConnectString string) *sql.DB {
+//带方言信息的数据库连接
+type DB struct {
+	*sql.DB
+	Driver DriverName
+	//每批 in 子句中的 id 数量
+	BatchSize int
+	//同时执行的批次数量
+	Parallel int
+	//单次检索的超时时间, 0 表示不限制
+	QueryTimeout time.Duration
+	//表及列名映射
+	Schema Schema
+	//按该时区计算任务的创建日期, 为空时使用 UTC, 与 Memory 一致
+	TimeZone string
+}
+
+func Connect(ctx context.Context, driver DriverName, dbConnectString string) (*DB, error) {
 	conn, err := sql.Open(string(driver), dbConnectString)
 	if err != nil {
-		log.Fatalln("connect db err: ", err)
+		return nil, &ConnectError{Driver: driver, Err: err}
+	}
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, &ConnectError{Driver: driver, Err: err}
+	}
+	return &DB{DB: conn, Driver: driver, BatchSize: DefaultBatchSize, Parallel: 1, Schema: DefaultSchema()}, nil
+}
+
+func (conn *DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if conn.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, conn.QueryTimeout)
+}
+
+//正在执行的检索数量
+var inFlight int64
+
+func InFlight() int64 {
+	return atomic.LoadInt64(&inFlight)
+}
+
+//记录开始执行检索, 返回的函数在检索结束时调用
+func startQuery() func() {
+	atomic.AddInt64(&inFlight, 1)
+	return func() {
+		atomic.AddInt64(&inFlight, -1)
 	}
-	return conn
 }
 
 //检索轨迹信息
-func QueryTrack(conn *sql.DB, snapIds []string) []Track {
-	inStr := strings.Join(snapIds, "','")
-	sql := fmt.Sprintf("select snap_id, people_id, type, device_id from viid_facestatic.people_track where snap_id in ('%s')", inStr)
-	rs, err := conn.Query(sql)
+func (conn *DB) QueryTrack(ctx context.Context, snapIds []string) ([]Track, []RejectedRow, error) {
+	return queryInBatches(ctx, conn, "track", snapIds, func(batch []string) ([]Track, []RejectedRow, error) {
+		return queryTrack(ctx, conn, batch)
+	})
+}
+
+func queryTrack(ctx context.Context, conn *DB, snapIds []string) ([]Track, []RejectedRow, error) {
+	var tracks []Track = make([]Track, 0)
+	var rejected []RejectedRow
+	s := conn.Schema
+	sqlStr := fmt.Sprintf("select %s from %s where %s in (%s)",
+		s.Cols(TablePeopleTrack, "snap_id", "people_id", "type", "device_id"), s.Table(TablePeopleTrack),
+		s.Col(TablePeopleTrack, "snap_id"), conn.Driver.Placeholders(1, len(snapIds)))
+	ctx, cancel := conn.withTimeout(ctx)
+	defer cancel()
+	defer startQuery()()
+	rs, err := conn.QueryContext(ctx, sqlStr, stringArgs(snapIds)...)
 	if err != nil {
-		log.Fatalln("query track err: ", err)
+		return nil, nil, &QueryError{Query: "track", Err: err}
 	}
 	defer rs.Close()
-	var tracks []Track = make([]Track, 0)
 	for rs.Next() {
-		var track Track
-		rs.Scan(&track.SnapId, &track.PeopleId, &track.TrackType, &track.DeviceId)
-		tracks = append(tracks, track)
+		var snapId, peopleId, deviceId sql.NullString
+		var trackType sql.NullInt64
+		if err := rs.Scan(&snapId, &peopleId, &trackType, &deviceId); err != nil {
+			rejected = append(rejected, RejectedRow{Query: "track", SnapId: snapId.String, Reason: err.Error()})
+			continue
+		}
+		if reason := nullColumns(column{"snap_id", snapId.Valid}, column{"people_id", peopleId.Valid}); reason != "" {
+			rejected = append(rejected, RejectedRow{Query: "track", SnapId: snapId.String, Reason: reason})
+			continue
+		}
+		//轨迹存在即说明已入档, 类型或设备为空时保留轨迹并记录
+		if reason := nullColumns(column{"type", trackType.Valid}, column{"device_id", deviceId.Valid}); reason != "" {
+			rejected = append(rejected, RejectedRow{Query: "track", SnapId: snapId.String, Reason: reason + KeptSuffix})
+		}
+		t := Track{SnapId: snapId.String, PeopleId: peopleId.String, TrackType: int(trackType.Int64), DeviceId: deviceId.String}
+		if !trackType.Valid {
+			t.TrackType = TrackTypeUnknown
+		}
+		tracks = append(tracks, t)
+	}
+	if err := rs.Err(); err != nil {
+		return nil, nil, &ScanError{Query: "track", Err: err}
 	}
-	return tracks
+	return tracks, rejected, nil
+}
+
+func (conn *DB) QueryTrash(ctx context.Context, snapIds []string) ([]Track, []RejectedRow, error) {
+	return queryInBatches(ctx, conn, "trash", snapIds, func(batch []string) ([]Track, []RejectedRow, error) {
+		return queryTrash(ctx, conn, batch)
+	})
 }
 
-func QueryTrash(conn *sql.DB, snapIds []string) []Track {
-	inStr := strings.Join(snapIds, "','")
-	sql := fmt.Sprintf("select record_id, discard_reason from viid_facestatic.trash_archive where record_id in ('%s')", inStr)
-	rs, err := conn.Query(sql)
+func queryTrash(ctx context.Context, conn *DB, snapIds []string) ([]Track, []RejectedRow, error) {
+	var tracks []Track = make([]Track, 0)
+	var rejected []RejectedRow
+	s := conn.Schema
+	sqlStr := fmt.Sprintf("select %s from %s where %s in (%s)",
+		s.Cols(TableTrashArchive, "record_id", "discard_reason"), s.Table(TableTrashArchive),
+		s.Col(TableTrashArchive, "record_id"), conn.Driver.Placeholders(1, len(snapIds)))
+	ctx, cancel := conn.withTimeout(ctx)
+	defer cancel()
+	defer startQuery()()
+	rs, err := conn.QueryContext(ctx, sqlStr, stringArgs(snapIds)...)
 	if err != nil {
-		log.Fatalln("query trash err: ", err)
+		return nil, nil, &QueryError{Query: "trash", Err: err}
 	}
-	var tracks []Track = make([]Track, 0)
+	defer rs.Close()
 	for rs.Next() {
-		var track Track
-		rs.Scan(&track.SnapId, &track.DiscardInfo)
-		tracks = append(tracks, track)
+		var recordId, discardReason sql.NullString
+		if err := rs.Scan(&recordId, &discardReason); err != nil {
+			rejected = append(rejected, RejectedRow{Query: "trash", SnapId: recordId.String, Reason: err.Error()})
+			continue
+		}
+		if reason := nullColumns(column{"record_id", recordId.Valid}, column{"discard_reason", discardReason.Valid}); reason != "" {
+			rejected = append(rejected, RejectedRow{Query: "trash", SnapId: recordId.String, Reason: reason})
+			continue
+		}
+		tracks = append(tracks, Track{SnapId: recordId.String, DiscardInfo: discardReason.String})
 	}
-	return tracks
+	if err := rs.Err(); err != nil {
+		return nil, nil, &ScanError{Query: "trash", Err: err}
+	}
+	return tracks, rejected, nil
 }
 
 //检索人脸
-func QueryFace(conn *sql.DB, faceIds []string) []FaceInfo {
-	inStr := strings.Join(faceIds, "','")
-	sqlStr := fmt.Sprintf("select faceid, deviceid, imageurlpart, passtime, imagereliability, roll, yaw, pitch from viid_facesnap.facesnapstructured_a050000 where faceid in ('%s')", inStr)
-	rs, err := conn.Query(sqlStr)
+func (conn *DB) QueryFace(ctx context.Context, faceIds []string) ([]FaceInfo, []RejectedRow, error) {
+	return queryInBatches(ctx, conn, "face", faceIds, func(batch []string) ([]FaceInfo, []RejectedRow, error) {
+		return queryFace(ctx, conn, batch)
+	})
+}
+
+func queryFace(ctx context.Context, conn *DB, faceIds []string) ([]FaceInfo, []RejectedRow, error) {
+	faceInfos := make([]FaceInfo, 0)
+	var rejected []RejectedRow
+	s := conn.Schema
+	sqlStr := fmt.Sprintf("select %s from %s where %s in (%s)",
+		s.Cols(TableFaceSnap, "faceid", "deviceid", "imageurlpart", "passtime", "imagereliability", "roll", "yaw", "pitch"),
+		s.Table(TableFaceSnap), s.Col(TableFaceSnap, "faceid"), conn.Driver.Placeholders(1, len(faceIds)))
+	ctx, cancel := conn.withTimeout(ctx)
+	defer cancel()
+	defer startQuery()()
+	rs, err := conn.QueryContext(ctx, sqlStr, stringArgs(faceIds)...)
 	if err != nil {
-		log.Fatalln("query face err: ", err)
+		return nil, nil, &QueryError{Query: "face", Err: err}
 	}
-	faceInfos := make([]FaceInfo, 0)
+	defer rs.Close()
 	for rs.Next() {
-		var face FaceInfo
-		imageUrl := sql.NullString{}
-		imageReliability := sql.NullInt32{}
-		roll := sql.NullFloat64{}
-		yaw := sql.NullFloat64{}
-		pitch := sql.NullFloat64{}
-		rs.Scan(&face.FaceId, &face.DeviceId, &imageUrl, &face.Passtime, &imageReliability, &roll, &yaw, &pitch)
-		face.ImageUrl = imageUrl.String
-		face.ImageReliability = int(imageReliability.Int32)
-		face.Roll = float32(roll.Float64)
-		face.Yaw = float32(yaw.Float64)
-		face.Pitch = float32(pitch.Float64)
-		faceInfos = append(faceInfos, face)
-	}
-	return faceInfos
+		var faceId, deviceId, imageUrl sql.NullString
+		var passtime, imageReliability sql.NullInt64
+		var roll, yaw, pitch sql.NullFloat64
+		if err := rs.Scan(&faceId, &deviceId, &imageUrl, &passtime, &imageReliability, &roll, &yaw, &pitch); err != nil {
+			rejected = append(rejected, RejectedRow{Query: "face", SnapId: faceId.String, Reason: err.Error()})
+			continue
+		}
+		if reason := nullColumns(column{"faceid", faceId.Valid}, column{"deviceid", deviceId.Valid}); reason != "" {
+			rejected = append(rejected, RejectedRow{Query: "face", SnapId: faceId.String, Reason: reason})
+			continue
+		}
+		faceInfos = append(faceInfos, FaceInfo{
+			FaceId:           faceId.String,
+			DeviceId:         deviceId.String,
+			ImageUrl:         imageUrl.String,
+			Passtime:         int(passtime.Int64),
+			ImageReliability: int(imageReliability.Int64),
+			Roll:             float32(roll.Float64),
+			Yaw:              float32(yaw.Float64),
+			Pitch:            float32(pitch.Float64),
+		})
+	}
+	if err := rs.Err(); err != nil {
+		return nil, nil, &ScanError{Query: "face", Err: err}
+	}
+	return faceInfos, rejected, nil
 }
 
 //检索人体
-func QueryPerson(conn *sql.DB, personIds []string) []PersonInfo {
-	inStr := strings.Join(personIds, "','")
-	sqlStr := fmt.Sprintf("select personid, deviceid, imageurlpart, linkfacepersonid, rightbtmx-lefttopx, rightbtmy-lefttopy from viid_person.personstructured_a050300 where personid in ('%s')", inStr)
-	rs, err := conn.Query(sqlStr)
+func (conn *DB) QueryPerson(ctx context.Context, personIds []string) ([]PersonInfo, []RejectedRow, error) {
+	return queryInBatches(ctx, conn, "person", personIds, func(batch []string) ([]PersonInfo, []RejectedRow, error) {
+		return queryPerson(ctx, conn, batch)
+	})
+}
+
+func queryPerson(ctx context.Context, conn *DB, personIds []string) ([]PersonInfo, []RejectedRow, error) {
+	personInfos := make([]PersonInfo, 0)
+	var rejected []RejectedRow
+	s := conn.Schema
+	sqlStr := fmt.Sprintf("select %s, %s-%s, %s-%s from %s where %s in (%s)",
+		s.Cols(TablePersonSnap, "personid", "deviceid", "imageurlpart", "linkfacepersonid"),
+		s.Col(TablePersonSnap, "rightbtmx"), s.Col(TablePersonSnap, "lefttopx"),
+		s.Col(TablePersonSnap, "rightbtmy"), s.Col(TablePersonSnap, "lefttopy"),
+		s.Table(TablePersonSnap), s.Col(TablePersonSnap, "personid"), conn.Driver.Placeholders(1, len(personIds)))
+	ctx, cancel := conn.withTimeout(ctx)
+	defer cancel()
+	defer startQuery()()
+	rs, err := conn.QueryContext(ctx, sqlStr, stringArgs(personIds)...)
 	if err != nil {
-		log.Fatalln("query person err: ", err)
+		return nil, nil, &QueryError{Query: "person", Err: err}
 	}
 	defer rs.Close()
-	personInfos := make([]PersonInfo, 0)
 	for rs.Next() {
-		var p PersonInfo
-		image := sql.NullString{String: "", Valid: false}
-		linkeFaceId := sql.NullString{String: "", Valid: false}
-		rs.Scan(&p.PersonId, &p.DeviceId, &image, &linkeFaceId, &p.Width, &p.Height)
-		p.ImageUrl = image.String
-		p.LinkFaceId = linkeFaceId.String
-		personInfos = append(personInfos, p)
+		var personId, deviceId, image, linkFaceId sql.NullString
+		var width, height sql.NullInt64
+		if err := rs.Scan(&personId, &deviceId, &image, &linkFaceId, &width, &height); err != nil {
+			rejected = append(rejected, RejectedRow{Query: "person", SnapId: personId.String, Reason: err.Error()})
+			continue
+		}
+		if reason := nullColumns(column{"personid", personId.Valid}, column{"deviceid", deviceId.Valid},
+			column{"width", width.Valid}, column{"height", height.Valid}); reason != "" {
+			rejected = append(rejected, RejectedRow{Query: "person", SnapId: personId.String, Reason: reason})
+			continue
+		}
+		personInfos = append(personInfos, PersonInfo{
+			PersonId:   personId.String,
+			DeviceId:   deviceId.String,
+			ImageUrl:   image.String,
+			LinkFaceId: linkFaceId.String,
+			Width:      int(width.Int64),
+			Height:     int(height.Int64),
+		})
 	}
-	return personInfos
+	if err := rs.Err(); err != nil {
+		return nil, nil, &ScanError{Query: "person", Err: err}
+	}
+	return personInfos, rejected, nil
 }
 
-func QueryTask(conn *sql.DB, date string) []string {
-	sqlStr := fmt.Sprintf("select work_task_id from pvid_person.person_archive_work_task where date(to_timestamp(create_time/1000)) = '%s'", date)
-	log.Println("query person task for date: ", date)
-	rs, err := conn.Query(sqlStr)
+func (conn *DB) QueryTask(ctx context.Context, date string) ([]string, []RejectedRow, error) {
+	tasks, rejected, err := conn.QueryTaskInfo(ctx, date)
 	if err != nil {
-		log.Println("query person task info err: ", err)
-		return nil
+		return nil, nil, err
 	}
-	result := make([]string, 0)
-	for rs.Next() {
-		var id string
-		rs.Scan(&id)
-		result = append(result, id)
+	result := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		result = append(result, t.WorkTaskId)
 	}
 	log.Println("person task to analyze: ", result)
-	return result
+	return result, rejected, nil
 }
 
-func QueryPersonArchiveIds(conn *sql.DB, ids []string) []string {
-	sqlStr := fmt.Sprintf("select device_id from pvid_system.device_info where device_id in ('%s') and archive_type = 2", strings.Join(ids, "','"))
-	log.Println("query person archive device")
-	rs, err := conn.Query(sqlStr)
+//检索指定日期创建的人体聚档任务, 日期按 TimeZone 计算而不依赖数据库会话时区,
+//结果按创建时间及任务 id 排序, 保证各次运行的任务顺序一致
+func (conn *DB) QueryTaskInfo(ctx context.Context, date string) ([]Task, []RejectedRow, error) {
+	s := conn.Schema
+	sqlStr := fmt.Sprintf("select %s from %s where date(to_timestamp(%s/1000) at time zone %s) = %s order by %s",
+		s.Cols(TableWorkTask, "work_task_id", "create_time"), s.Table(TableWorkTask),
+		s.Col(TableWorkTask, "create_time"), conn.Driver.Placeholder(2), conn.Driver.Placeholder(1),
+		s.Cols(TableWorkTask, "create_time", "work_task_id"))
+	zone := conn.TimeZone
+	if zone == "" {
+		zone = "UTC"
+	}
+	log.Println("query person task for date: ", date, zone)
+	ctx, cancel := conn.withTimeout(ctx)
+	defer cancel()
+	defer startQuery()()
+	rs, err := conn.QueryContext(ctx, sqlStr, date, zone)
 	if err != nil {
-		log.Println("query person archive device err: ", err)
-		return nil
+		return nil, nil, &QueryError{Query: "person task", Err: err}
 	}
+	defer rs.Close()
+	result := make([]Task, 0)
+	var rejected []RejectedRow
+	for rs.Next() {
+		var id sql.NullString
+		var createTime sql.NullInt64
+		if err := rs.Scan(&id, &createTime); err != nil {
+			rejected = append(rejected, RejectedRow{Query: "person task", SnapId: id.String, Reason: err.Error()})
+			continue
+		}
+		if reason := nullColumns(column{"work_task_id", id.Valid}, column{"create_time", createTime.Valid}); reason != "" {
+			rejected = append(rejected, RejectedRow{Query: "person task", SnapId: id.String, Reason: reason})
+			continue
+		}
+		result = append(result, Task{WorkTaskId: id.String, CreateTime: createTime.Int64})
+	}
+	if err := rs.Err(); err != nil {
+		return nil, nil, &ScanError{Query: "person task", Err: err}
+	}
+	return result, rejected, nil
+}
+
+func (conn *DB) QueryPersonArchiveIds(ctx context.Context, ids []string) ([]string, []RejectedRow, error) {
+	return queryInBatches(ctx, conn, "person archive device", ids, func(batch []string) ([]string, []RejectedRow, error) {
+		return queryPersonArchiveIds(ctx, conn, batch)
+	})
+}
+
+func queryPersonArchiveIds(ctx context.Context, conn *DB, ids []string) ([]string, []RejectedRow, error) {
 	result := make([]string, 0)
+	var rejected []RejectedRow
+	s := conn.Schema
+	sqlStr := fmt.Sprintf("select %s from %s where %s in (%s) and %s = 2",
+		s.Col(TableDeviceInfo, "device_id"), s.Table(TableDeviceInfo), s.Col(TableDeviceInfo, "device_id"),
+		conn.Driver.Placeholders(1, len(ids)), s.Col(TableDeviceInfo, "archive_type"))
+	log.Println("query person archive device")
+	ctx, cancel := conn.withTimeout(ctx)
+	defer cancel()
+	defer startQuery()()
+	rs, err := conn.QueryContext(ctx, sqlStr, stringArgs(ids)...)
+	if err != nil {
+		return nil, nil, &QueryError{Query: "person archive device", Err: err}
+	}
+	defer rs.Close()
 	for rs.Next() {
-		var id string
-		rs.Scan(&id)
-		result = append(result, id)
+		var id sql.NullString
+		if err := rs.Scan(&id); err != nil {
+			rejected = append(rejected, RejectedRow{Query: "person archive device", Reason: err.Error()})
+			continue
+		}
+		if reason := nullColumns(column{"device_id", id.Valid}); reason != "" {
+			rejected = append(rejected, RejectedRow{Query: "person archive device", Reason: reason})
+			continue
+		}
+		result = append(result, id.String)
+	}
+	if err := rs.Err(); err != nil {
+		return nil, nil, &ScanError{Query: "person archive device", Err: err}
 	}
 	log.Println("person device id: ", result)
-	return result
+	return result, rejected, nil
 }
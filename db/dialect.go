@@ -0,0 +1,31 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+)
+
+//占位符语法: vertica 使用 ?, postgres 使用 $n (n 从 1 开始)
+func (d DriverName) Placeholder(n int) string {
+	if d == PG {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+//生成 in 子句所需的占位符列表, start 为第一个参数的序号
+func (d DriverName) Placeholders(start, count int) string {
+	ps := make([]string, count)
+	for i := 0; i < count; i++ {
+		ps[i] = d.Placeholder(start + i)
+	}
+	return strings.Join(ps, ", ")
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
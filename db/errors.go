@@ -0,0 +1,45 @@
+package db
+
+import "fmt"
+
+//连接数据库失败
+type ConnectError struct {
+	Driver DriverName
+	Err    error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("connect %s err: %v", e.Driver, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+//执行检索失败
+type QueryError struct {
+	Query string
+	Err   error
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query %s err: %v", e.Query, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+//读取检索结果失败
+type ScanError struct {
+	Query string
+	Err   error
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("scan %s err: %v", e.Query, e.Err)
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
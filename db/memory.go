@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+//人体聚档任务
+type Task struct {
+	WorkTaskId string
+	//创建时间, 毫秒
+	CreateTime int64
+}
+
+//设备聚档信息, ArchiveType 为 2 表示人体聚档设备
+type Device struct {
+	DeviceId    string
+	ArchiveType int
+}
+
+//内存中的数据, 不依赖数据库运行分析
+type Memory struct {
+	Tracks  []Track
+	Trashes []Track
+	Faces   []FaceInfo
+	Persons []PersonInfo
+	Tasks   []Task
+	Devices []Device
+	//导出日期, 来自快照清单, 没有清单时为空
+	Date string
+	//按该时区的创建日期过滤任务, 为 nil 时使用 UTC, 与 DB.TimeZone 一致
+	Location *time.Location
+	//按日期记录的任务列表, 来自导出时数据库的检索结果, 优先于按创建时间过滤
+	TaskDates map[string][]string
+	//加载时无法读取的数据行, 检索时按 Query 和 SnapId 返回
+	Rejected []RejectedRow
+}
+
+//从 json 夹具文件加载数据, 字段名与 Memory 一致
+func LoadFixture(path string) (*Memory, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Memory
+	if err := json.Unmarshal(bs, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+//返回指定检索中属于 ids 的无法读取的数据行
+func (m *Memory) rejected(query string, set map[string]struct{}) []RejectedRow {
+	var rejected []RejectedRow
+	for _, r := range m.Rejected {
+		if r.Query != query {
+			continue
+		}
+		if _, ok := set[r.SnapId]; ok || set == nil {
+			rejected = append(rejected, r)
+		}
+	}
+	return rejected
+}
+
+func idSet(ids []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set
+}
+
+func (m *Memory) QueryTrack(ctx context.Context, snapIds []string) ([]Track, []RejectedRow, error) {
+	set := idSet(snapIds)
+	tracks := make([]Track, 0)
+	for _, t := range m.Tracks {
+		if _, ok := set[t.SnapId]; ok {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks, m.rejected("track", set), nil
+}
+
+func (m *Memory) QueryTrash(ctx context.Context, snapIds []string) ([]Track, []RejectedRow, error) {
+	set := idSet(snapIds)
+	tracks := make([]Track, 0)
+	for _, t := range m.Trashes {
+		if _, ok := set[t.SnapId]; ok {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks, m.rejected("trash", set), nil
+}
+
+func (m *Memory) QueryFace(ctx context.Context, faceIds []string) ([]FaceInfo, []RejectedRow, error) {
+	set := idSet(faceIds)
+	faceInfos := make([]FaceInfo, 0)
+	for _, f := range m.Faces {
+		if _, ok := set[f.FaceId]; ok {
+			faceInfos = append(faceInfos, f)
+		}
+	}
+	return faceInfos, m.rejected("face", set), nil
+}
+
+func (m *Memory) QueryPerson(ctx context.Context, personIds []string) ([]PersonInfo, []RejectedRow, error) {
+	set := idSet(personIds)
+	personInfos := make([]PersonInfo, 0)
+	for _, p := range m.Persons {
+		if _, ok := set[p.PersonId]; ok {
+			personInfos = append(personInfos, p)
+		}
+	}
+	return personInfos, m.rejected("person", set), nil
+}
+
+//有该日期的任务列表时直接返回, 否则按 Location 的创建日期过滤, 结果与运行所在时区无关
+func (m *Memory) QueryTask(ctx context.Context, date string) ([]string, []RejectedRow, error) {
+	if tasks, ok := m.TaskDates[date]; ok {
+		return append([]string{}, tasks...), m.rejected("person task", nil), nil
+	}
+	loc := m.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	var tasks []Task
+	for _, t := range m.Tasks {
+		if time.UnixMilli(t.CreateTime).In(loc).Format("2006-01-02") == date {
+			tasks = append(tasks, t)
+		}
+	}
+	//与 DB.QueryTaskInfo 的排序一致
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].CreateTime != tasks[j].CreateTime {
+			return tasks[i].CreateTime < tasks[j].CreateTime
+		}
+		return tasks[i].WorkTaskId < tasks[j].WorkTaskId
+	})
+	result := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		result = append(result, t.WorkTaskId)
+	}
+	return result, m.rejected("person task", nil), nil
+}
+
+func (m *Memory) QueryPersonArchiveIds(ctx context.Context, ids []string) ([]string, []RejectedRow, error) {
+	set := idSet(ids)
+	result := make([]string, 0)
+	for _, d := range m.Devices {
+		if _, ok := set[d.DeviceId]; ok && d.ArchiveType == 2 {
+			result = append(result, d.DeviceId)
+		}
+	}
+	return result, m.rejected("person archive device", set), nil
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryQueryTaskLocation(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	//UTC 2023-04-14 20:00, 上海 2023-04-15 04:00
+	m := &Memory{Tasks: []Task{{WorkTaskId: "task1", CreateTime: 1681502400000}}}
+	tests := []struct {
+		loc  *time.Location
+		date string
+		want []string
+	}{
+		{nil, "2023-04-14", []string{"task1"}},
+		{nil, "2023-04-15", []string{}},
+		{shanghai, "2023-04-15", []string{"task1"}},
+		{shanghai, "2023-04-14", []string{}},
+	}
+	for _, tt := range tests {
+		m.Location = tt.loc
+		got, _, err := m.QueryTask(context.Background(), tt.date)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("QueryTask(%s) in %v = %v, %v, want %v", tt.date, tt.loc, got, err, tt.want)
+		}
+	}
+}
+
+func TestMemoryQueryTaskOrder(t *testing.T) {
+	m := &Memory{Tasks: []Task{
+		{WorkTaskId: "task3", CreateTime: 1681520400000},
+		{WorkTaskId: "task2", CreateTime: 1681516800000},
+		{WorkTaskId: "task1", CreateTime: 1681516800000},
+	}}
+	got, _, err := m.QueryTask(context.Background(), "2023-04-15")
+	if want := []string{"task1", "task2", "task3"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryTask = %v, %v, want %v", got, err, want)
+	}
+}
@@ -0,0 +1,32 @@
+package db
+
+import "strings"
+
+//无法读取或部分列为空的数据行, SnapId 为该行能读到的主键(可能为空)
+type RejectedRow struct {
+	Query  string `json:"query"`
+	SnapId string `json:"snapId"`
+	Reason string `json:"reason"`
+}
+
+//行中非必填列为空, 该行仍参与分析时 Reason 的后缀
+const KeptSuffix = ", row kept"
+
+type column struct {
+	name  string
+	valid bool
+}
+
+//返回必填列为空的原因, 全部非空时返回空串
+func nullColumns(cols ...column) string {
+	var names []string
+	for _, c := range cols {
+		if !c.valid {
+			names = append(names, c.name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return strings.Join(names, ",") + " is null"
+}
@@ -0,0 +1,25 @@
+package db
+
+import "context"
+
+//抓拍及轨迹相关检索, 对应 MPP 数据库
+type SnapRepository interface {
+	QueryTrack(ctx context.Context, snapIds []string) ([]Track, []RejectedRow, error)
+	QueryTrash(ctx context.Context, snapIds []string) ([]Track, []RejectedRow, error)
+	QueryFace(ctx context.Context, faceIds []string) ([]FaceInfo, []RejectedRow, error)
+	QueryPerson(ctx context.Context, personIds []string) ([]PersonInfo, []RejectedRow, error)
+}
+
+//人体聚档任务及设备相关检索, 对应 PG 数据库
+type ArchiveRepository interface {
+	QueryTask(ctx context.Context, date string) ([]string, []RejectedRow, error)
+	QueryPersonArchiveIds(ctx context.Context, ids []string) ([]string, []RejectedRow, error)
+}
+
+var (
+	_ SnapRepository    = (*DB)(nil)
+	_ ArchiveRepository = (*DB)(nil)
+	_ SnapRepository    = (*Memory)(nil)
+	_ ArchiveRepository = (*Memory)(nil)
+	_ ArchiveRepository = (*CachedArchive)(nil)
+)
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//表的实际名称及列映射, Columns 的键为程序中使用的列名, 值为实际列名
+type Table struct {
+	Name    string            `yaml:"name"`
+	Columns map[string]string `yaml:"columns"`
+}
+
+//表映射, 键为程序中使用的表名(与离线快照文件名一致)
+type Schema map[string]Table
+
+//MPP 与 PG 数据库中分别使用的表
+var (
+	SnapTables    = []string{TablePeopleTrack, TableTrashArchive, TableFaceSnap, TablePersonSnap}
+	ArchiveTables = []string{TableWorkTask, TableDeviceInfo}
+)
+
+func identity(cols ...string) map[string]string {
+	m := make(map[string]string, len(cols))
+	for _, c := range cols {
+		m[c] = c
+	}
+	return m
+}
+
+//默认表映射
+func DefaultSchema() Schema {
+	return Schema{
+		TablePeopleTrack: {Name: "viid_facestatic.people_track",
+			Columns: identity("snap_id", "people_id", "type", "device_id")},
+		TableTrashArchive: {Name: "viid_facestatic.trash_archive",
+			Columns: identity("record_id", "discard_reason")},
+		TableFaceSnap: {Name: "viid_facesnap.facesnapstructured_a050000",
+			Columns: identity("faceid", "deviceid", "imageurlpart", "passtime", "imagereliability", "roll", "yaw", "pitch")},
+		TablePersonSnap: {Name: "viid_person.personstructured_a050300",
+			Columns: identity("personid", "deviceid", "imageurlpart", "linkfacepersonid", "lefttopx", "lefttopy", "rightbtmx", "rightbtmy")},
+		TableWorkTask: {Name: "pvid_person.person_archive_work_task",
+			Columns: identity("work_task_id", "create_time")},
+		TableDeviceInfo: {Name: "pvid_system.device_info",
+			Columns: identity("device_id", "archive_type")},
+	}
+}
+
+//以 o 中配置的表名和列名覆盖 s, 未配置的保持不变
+func (s Schema) Merge(o Schema) (Schema, error) {
+	merged := make(Schema, len(s))
+	for name, t := range s {
+		columns := make(map[string]string, len(t.Columns))
+		for k, v := range t.Columns {
+			columns[k] = v
+		}
+		merged[name] = Table{Name: t.Name, Columns: columns}
+	}
+	for name, t := range o {
+		base, ok := merged[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown table: %s", name)
+		}
+		if t.Name != "" {
+			base.Name = t.Name
+		}
+		for k, v := range t.Columns {
+			if _, ok := base.Columns[k]; !ok {
+				return nil, fmt.Errorf("unknown column %s of table %s", k, name)
+			}
+			base.Columns[k] = v
+		}
+		merged[name] = base
+	}
+	return merged, nil
+}
+
+//实际表名
+func (s Schema) Table(table string) string {
+	return s[table].Name
+}
+
+//实际列名
+func (s Schema) Col(table, col string) string {
+	return s[table].Columns[col]
+}
+
+//实际列名到程序中列名的映射, 用于读取按实际列名导出的离线快照
+func (s Schema) logical(table string) map[string]string {
+	m := make(map[string]string, len(s[table].Columns))
+	for k, v := range s[table].Columns {
+		m[v] = k
+	}
+	return m
+}
+
+//按顺序返回实际列名, 用于拼接 select 子句
+func (s Schema) Cols(table string, cols ...string) string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = s.Col(table, c)
+	}
+	return strings.Join(names, ", ")
+}
+
+//校验配置的表及列在数据库中存在, 返回所有校验失败的表
+func (conn *DB) ValidateSchema(ctx context.Context, tables []string) error {
+	var failures []string
+	for _, table := range tables {
+		t := conn.Schema[table]
+		cols := make([]string, 0, len(t.Columns))
+		for k := range t.Columns {
+			cols = append(cols, k)
+		}
+		sort.Strings(cols)
+		sqlStr := fmt.Sprintf("select %s from %s where 1 = 0", conn.Schema.Cols(table, cols...), t.Name)
+		qctx, cancel := conn.withTimeout(ctx)
+		rs, err := conn.QueryContext(qctx, sqlStr)
+		if err == nil {
+			rs.Close()
+		} else {
+			failures = append(failures, fmt.Sprintf("%s(%s): %v", table, t.Name, err))
+		}
+		cancel()
+	}
+	if len(failures) > 0 {
+		return &QueryError{Query: "schema", Err: fmt.Errorf("%s", strings.Join(failures, "; "))}
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package db
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+//离线快照目录中每张表对应一个 <表名>.csv 或 <表名>.json 文件,
+//csv 首行为列名, json 为以列名为键的对象数组, 列名为默认列名或按配置映射的实际列名, 空值视为 NULL
+const (
+	TablePeopleTrack  = "people_track"
+	TableTrashArchive = "trash_archive"
+	TableFaceSnap     = "facesnapstructured_a050000"
+	TablePersonSnap   = "personstructured_a050300"
+	TableWorkTask     = "person_archive_work_task"
+	TableDeviceInfo   = "device_info"
+)
+
+type snapshotRow struct {
+	values  map[string]string
+	missing []column
+	err     error
+}
+
+func (r *snapshotRow) str(col string, required bool) string {
+	v, ok := r.values[col]
+	if required && (!ok || v == "") {
+		r.missing = append(r.missing, column{name: col})
+	}
+	return v
+}
+
+func (r *snapshotRow) int(col string, required bool) int64 {
+	v := r.str(col, required)
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("column %s: %v", col, err)
+	}
+	return i
+}
+
+func (r *snapshotRow) float(col string) float64 {
+	v := r.str(col, false)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("column %s: %v", col, err)
+	}
+	return f
+}
+
+//行无法读取时的原因, 可读取时返回空串
+func (r *snapshotRow) reason() string {
+	if r.err != nil {
+		return r.err.Error()
+	}
+	return nullColumns(r.missing...)
+}
+
+type snapshotTable struct {
+	name  string
+	query string
+	//主键列, 用于记录无法读取的行
+	key  string
+	load func(m *Memory, r *snapshotRow)
+}
+
+var snapshotTables = []snapshotTable{
+	{name: TablePeopleTrack, query: "track", key: "snap_id", load: func(m *Memory, r *snapshotRow) {
+		t := Track{SnapId: r.str("snap_id", true), PeopleId: r.str("people_id", true),
+			TrackType: int(r.int("type", false)), DeviceId: r.str("device_id", false)}
+		if r.reason() != "" {
+			return
+		}
+		//与数据库检索一致, 类型或设备为空时保留轨迹并记录
+		if r.values["type"] == "" {
+			t.TrackType = TrackTypeUnknown
+		}
+		if reason := nullColumns(column{"type", r.values["type"] != ""}, column{"device_id", t.DeviceId != ""}); reason != "" {
+			m.Rejected = append(m.Rejected, RejectedRow{Query: "track", SnapId: t.SnapId, Reason: reason + KeptSuffix})
+		}
+		m.Tracks = append(m.Tracks, t)
+	}},
+	{name: TableTrashArchive, query: "trash", key: "record_id", load: func(m *Memory, r *snapshotRow) {
+		t := Track{SnapId: r.str("record_id", true), DiscardInfo: r.str("discard_reason", true)}
+		if r.reason() == "" {
+			m.Trashes = append(m.Trashes, t)
+		}
+	}},
+	{name: TableFaceSnap, query: "face", key: "faceid", load: func(m *Memory, r *snapshotRow) {
+		f := FaceInfo{
+			FaceId:           r.str("faceid", true),
+			DeviceId:         r.str("deviceid", true),
+			ImageUrl:         r.str("imageurlpart", false),
+			Passtime:         int(r.int("passtime", false)),
+			ImageReliability: int(r.int("imagereliability", false)),
+			Roll:             float32(r.float("roll")),
+			Yaw:              float32(r.float("yaw")),
+			Pitch:            float32(r.float("pitch")),
+		}
+		if r.reason() == "" {
+			m.Faces = append(m.Faces, f)
+		}
+	}},
+	{name: TablePersonSnap, query: "person", key: "personid", load: func(m *Memory, r *snapshotRow) {
+		p := PersonInfo{
+			PersonId:   r.str("personid", true),
+			DeviceId:   r.str("deviceid", true),
+			ImageUrl:   r.str("imageurlpart", false),
+			LinkFaceId: r.str("linkfacepersonid", false),
+		}
+		//导出的快照直接记录宽高, 数据库导出的原始表记录坐标
+		if _, ok := r.values["width"]; ok {
+			p.Width = int(r.int("width", true))
+			p.Height = int(r.int("height", true))
+		} else {
+			p.Width = int(r.int("rightbtmx", true) - r.int("lefttopx", true))
+			p.Height = int(r.int("rightbtmy", true) - r.int("lefttopy", true))
+		}
+		if r.reason() == "" {
+			m.Persons = append(m.Persons, p)
+		}
+	}},
+	{name: TableWorkTask, query: "person task", key: "work_task_id", load: func(m *Memory, r *snapshotRow) {
+		t := Task{WorkTaskId: r.str("work_task_id", true), CreateTime: r.int("create_time", true)}
+		if r.reason() == "" {
+			m.Tasks = append(m.Tasks, t)
+		}
+	}},
+	{name: TableDeviceInfo, query: "person archive device", key: "device_id", load: func(m *Memory, r *snapshotRow) {
+		d := Device{DeviceId: r.str("device_id", true), ArchiveType: int(r.int("archive_type", true))}
+		if r.reason() == "" {
+			m.Devices = append(m.Devices, d)
+		}
+	}},
+}
+
+//快照格式版本, 格式不兼容时递增
+const SnapshotVersion = 1
+
+//快照包清单文件, 手工编写的快照目录可以没有
+const ManifestFile = "manifest.json"
+
+//快照包清单, 与各表数据一起写入 manifest.json
+type Manifest struct {
+	Version   int            `json:"version"`
+	CreatedAt string         `json:"createdAt"`
+	Date      string         `json:"date"`
+	Tasks     []string       `json:"tasks"`
+	IdFiles   []string       `json:"idFiles"`
+	Rows      map[string]int `json:"rows"`
+	Rejected  []RejectedRow  `json:"rejected"`
+}
+
+//将数据序列化为快照目录中各表的 json 文件内容, 键为文件名
+func MarshalSnapshot(m *Memory) (map[string][]byte, error) {
+	tables := map[string][]map[string]interface{}{
+		TablePeopleTrack:  {},
+		TableTrashArchive: {},
+		TableFaceSnap:     {},
+		TablePersonSnap:   {},
+		TableWorkTask:     {},
+		TableDeviceInfo:   {},
+	}
+	for _, t := range m.Tracks {
+		tables[TablePeopleTrack] = append(tables[TablePeopleTrack], map[string]interface{}{
+			"snap_id": t.SnapId, "people_id": t.PeopleId, "type": t.TrackType, "device_id": t.DeviceId})
+	}
+	for _, t := range m.Trashes {
+		tables[TableTrashArchive] = append(tables[TableTrashArchive], map[string]interface{}{
+			"record_id": t.SnapId, "discard_reason": t.DiscardInfo})
+	}
+	for _, f := range m.Faces {
+		tables[TableFaceSnap] = append(tables[TableFaceSnap], map[string]interface{}{
+			"faceid": f.FaceId, "deviceid": f.DeviceId, "imageurlpart": f.ImageUrl, "passtime": f.Passtime,
+			"imagereliability": f.ImageReliability, "roll": f.Roll, "yaw": f.Yaw, "pitch": f.Pitch})
+	}
+	for _, p := range m.Persons {
+		tables[TablePersonSnap] = append(tables[TablePersonSnap], map[string]interface{}{
+			"personid": p.PersonId, "deviceid": p.DeviceId, "imageurlpart": p.ImageUrl,
+			"linkfacepersonid": p.LinkFaceId, "width": p.Width, "height": p.Height})
+	}
+	for _, t := range m.Tasks {
+		tables[TableWorkTask] = append(tables[TableWorkTask], map[string]interface{}{
+			"work_task_id": t.WorkTaskId, "create_time": t.CreateTime})
+	}
+	for _, d := range m.Devices {
+		tables[TableDeviceInfo] = append(tables[TableDeviceInfo], map[string]interface{}{
+			"device_id": d.DeviceId, "archive_type": d.ArchiveType})
+	}
+	files := make(map[string][]byte, len(tables))
+	for name, rows := range tables {
+		bs, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		files[name+".json"] = bs
+	}
+	return files, nil
+}
+
+//从离线快照目录加载数据, 缺失的表视为空表. 有清单时校验快照版本, 并以清单中导出日期的任务列表为准.
+//列名可以是程序中的列名或 schema 中配置的实际列名, schema 为 nil 时只识别程序中的列名
+func LoadSnapshot(dir string, schema Schema) (*Memory, error) {
+	m := &Memory{}
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		if manifest.Version != SnapshotVersion {
+			return nil, fmt.Errorf("unsupported snapshot version %d in %s, expect %d", manifest.Version, ManifestFile, SnapshotVersion)
+		}
+		m.Date = manifest.Date
+		//导出时数据库中无法读取的行不在表文件中, 由清单带回
+		m.Rejected = append(m.Rejected, manifest.Rejected...)
+		m.TaskDates = map[string][]string{manifest.Date: manifest.Tasks}
+	}
+	for _, t := range snapshotTables {
+		rows, err := readSnapshotTable(dir, t.name)
+		if err != nil {
+			return nil, err
+		}
+		columns := schema.logical(t.name)
+		for _, values := range rows {
+			values = renameColumns(values, columns)
+			r := &snapshotRow{values: values}
+			t.load(m, r)
+			if reason := r.reason(); reason != "" {
+				m.Rejected = append(m.Rejected, RejectedRow{Query: t.query, SnapId: values[t.key], Reason: reason})
+			}
+		}
+	}
+	return m, nil
+}
+
+//按 columns 将实际列名改为程序中的列名, 不在映射中的列保持不变
+func renameColumns(values map[string]string, columns map[string]string) map[string]string {
+	renamed := make(map[string]string, len(values))
+	for k, v := range values {
+		if c, ok := columns[k]; ok {
+			k = c
+		}
+		renamed[k] = v
+	}
+	return renamed
+}
+
+//读取快照目录中的清单, 不存在时返回 nil
+func readManifest(dir string) (*Manifest, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(bs, &manifest); err != nil {
+		return nil, fmt.Errorf("read snapshot %s err: %v", ManifestFile, err)
+	}
+	return &manifest, nil
+}
+
+func readSnapshotTable(dir string, table string) ([]map[string]string, error) {
+	csvPath := filepath.Join(dir, table+".csv")
+	if f, err := os.Open(csvPath); err == nil {
+		defer f.Close()
+		rows, err := readCsvRows(f)
+		if err != nil {
+			return nil, fmt.Errorf("read snapshot %s err: %v", csvPath, err)
+		}
+		return rows, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	jsonPath := filepath.Join(dir, table+".json")
+	if f, err := os.Open(jsonPath); err == nil {
+		defer f.Close()
+		rows, err := readJsonRows(f)
+		if err != nil {
+			return nil, fmt.Errorf("read snapshot %s err: %v", jsonPath, err)
+		}
+		return rows, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func readCsvRows(reader io.Reader) ([]map[string]string, error) {
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	header := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(record) && record[i] != "" {
+				row[col] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func readJsonRows(reader io.Reader) ([]map[string]string, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	var objects []map[string]interface{}
+	if err := decoder.Decode(&objects); err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]string, 0, len(objects))
+	for _, object := range objects {
+		row := make(map[string]string, len(object))
+		for col, v := range object {
+			if v != nil {
+				row[col] = fmt.Sprint(v)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
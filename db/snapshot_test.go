@@ -0,0 +1,26 @@
+package db
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSnapshotSchemaColumns(t *testing.T) {
+	dir := t.TempDir()
+	csv := "personid,deviceid,link_face_person_id,width,height\np1,dev1,f1,100,200\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, TablePersonSnap+".csv"), []byte(csv), 0666); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := DefaultSchema().Merge(Schema{TablePersonSnap: {Columns: map[string]string{"linkfacepersonid": "link_face_person_id"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadSnapshot(dir, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Persons) != 1 || m.Persons[0].LinkFaceId != "f1" {
+		t.Errorf("persons = %+v, want link face f1 from the mapped column", m.Persons)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"dytest/db"
+	"dytest/file"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//快照包写入目标, 目录或 tar.gz
+type bundleWriter interface {
+	WriteFile(name string, data []byte) error
+	//写入 r 中 size 字节, 不将内容整体读入内存
+	Copy(name string, r io.Reader, size int64) error
+	Close() error
+}
+
+type dirBundle struct {
+	root string
+}
+
+func (b dirBundle) WriteFile(name string, data []byte) error {
+	p := filepath.Join(b.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, data, 0644)
+}
+
+func (b dirBundle) Copy(name string, r io.Reader, size int64) error {
+	p := filepath.Join(b.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (b dirBundle) Close() error {
+	return nil
+}
+
+type tarBundle struct {
+	f    *os.File
+	gz   *gzip.Writer
+	tw   *tar.Writer
+	base string
+}
+
+func (b *tarBundle) WriteFile(name string, data []byte) error {
+	header := &tar.Header{Name: path.Join(b.base, name), Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := b.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := b.tw.Write(data)
+	return err
+}
+
+func (b *tarBundle) Copy(name string, r io.Reader, size int64) error {
+	header := &tar.Header{Name: path.Join(b.base, name), Mode: 0644, Size: size, ModTime: time.Now()}
+	if err := b.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.CopyN(b.tw, r, size)
+	return err
+}
+
+//出错时同样关闭文件
+func (b *tarBundle) Close() error {
+	err := b.tw.Close()
+	if gzErr := b.gz.Close(); err == nil {
+		err = gzErr
+	}
+	if fErr := b.f.Close(); err == nil {
+		err = fErr
+	}
+	return err
+}
+
+//快照包压缩文件的扩展名
+var bundleExts = []string{".tar.gz", ".tgz"}
+
+func bundleExt(name string) (string, bool) {
+	for _, ext := range bundleExts {
+		if strings.HasSuffix(name, ext) {
+			return ext, true
+		}
+	}
+	return "", false
+}
+
+//输出以 .tar.gz 或 .tgz 结尾时写入压缩包, 否则写入目录
+func openBundle(output string) (bundleWriter, error) {
+	if ext, ok := bundleExt(output); ok {
+		f, err := os.Create(output)
+		if err != nil {
+			return nil, err
+		}
+		gz := gzip.NewWriter(f)
+		base := strings.TrimSuffix(filepath.Base(output), ext)
+		return &tarBundle{f: f, gz: gz, tw: tar.NewWriter(gz), base: base}, nil
+	}
+	if err := os.MkdirAll(output, 0777); err != nil {
+		return nil, err
+	}
+	return dirBundle{root: output}, nil
+}
+
+//收集导出的数据行, 按主键去重
+type snapshotCollector struct {
+	m        db.Memory
+	tracks   map[string]struct{}
+	trashes  map[string]struct{}
+	faces    map[string]struct{}
+	persons  map[string]struct{}
+	devices  map[string]struct{}
+	rejected []db.RejectedRow
+}
+
+func newSnapshotCollector() *snapshotCollector {
+	return &snapshotCollector{
+		tracks:  make(map[string]struct{}),
+		trashes: make(map[string]struct{}),
+		faces:   make(map[string]struct{}),
+		persons: make(map[string]struct{}),
+		devices: make(map[string]struct{}),
+	}
+}
+
+func firstSeen(seen map[string]struct{}, key string) bool {
+	if _, ok := seen[key]; ok {
+		return false
+	}
+	seen[key] = struct{}{}
+	return true
+}
+
+func (c *snapshotCollector) collectSnaps(ctx context.Context, repo db.SnapRepository, faceIds, personIds []string) error {
+	faces, rejected, err := repo.QueryFace(ctx, faceIds)
+	if err != nil {
+		return err
+	}
+	c.rejected = append(c.rejected, rejected...)
+	for _, f := range faces {
+		if firstSeen(c.faces, f.FaceId) {
+			c.m.Faces = append(c.m.Faces, f)
+		}
+	}
+	persons, rejected, err := repo.QueryPerson(ctx, personIds)
+	if err != nil {
+		return err
+	}
+	c.rejected = append(c.rejected, rejected...)
+	for _, p := range persons {
+		if firstSeen(c.persons, p.PersonId) {
+			c.m.Persons = append(c.m.Persons, p)
+		}
+	}
+	tracks, rejected, err := repo.QueryTrack(ctx, append(append([]string{}, faceIds...), personIds...))
+	if err != nil {
+		return err
+	}
+	c.rejected = append(c.rejected, rejected...)
+	for _, t := range tracks {
+		if firstSeen(c.tracks, t.SnapId) {
+			c.m.Tracks = append(c.m.Tracks, t)
+		}
+	}
+	trashes, rejected, err := repo.QueryTrash(ctx, faceIds)
+	if err != nil {
+		return err
+	}
+	c.rejected = append(c.rejected, rejected...)
+	for _, t := range trashes {
+		if firstSeen(c.trashes, t.SnapId) {
+			c.m.Trashes = append(c.m.Trashes, t)
+		}
+	}
+	return nil
+}
+
+//导出命令: 检索分析会用到的全部数据行及 S3 档案文件, 写入可离线复现的快照包
+func export(ctx context.Context) int {
+	conn, pg := connect(ctx)
+	defer conn.Close()
+	defer pg.Close()
+
+	is, err := file.ReadDir(dir)
+	if err != nil {
+		log.Fatalln("read dir err: ", dir)
+	}
+	c := newSnapshotCollector()
+	var personIds []string
+	for _, i := range is {
+		log.Println("export rows for: ", i.Name)
+		if err := c.collectSnaps(ctx, conn, i.FaceIds, i.PersonIds); err != nil {
+			log.Println("export err: ", err)
+			return exitFailed
+		}
+		personIds = append(personIds, i.PersonIds...)
+	}
+
+	tasks, rejected, err := pg.QueryTaskInfo(ctx, date)
+	if err != nil {
+		log.Println("export err: ", err)
+		return exitFailed
+	}
+	c.rejected = append(c.rejected, rejected...)
+	c.m.Tasks = tasks
+	var taskIds []string
+	for _, t := range tasks {
+		taskIds = append(taskIds, t.WorkTaskId)
+	}
+	var deviceIds []string
+	for _, p := range c.m.Persons {
+		deviceIds = append(deviceIds, p.DeviceId)
+	}
+	archived, rejected, err := pg.QueryPersonArchiveIds(ctx, deviceIds)
+	if err != nil {
+		log.Println("export err: ", err)
+		return exitFailed
+	}
+	c.rejected = append(c.rejected, rejected...)
+	for _, d := range archived {
+		if firstSeen(c.devices, d) {
+			c.m.Devices = append(c.m.Devices, db.Device{DeviceId: d, ArchiveType: 2})
+		}
+	}
+
+	//初始档案需要继续检索档案内人体及其关联人脸的轨迹
+	s3Results, err := file.ReadTaskResult(ctx, store, taskIds, file.NewIdFilter(personIds), cfg.StrictArchive)
+	if err != nil {
+		log.Println("export err: ", err)
+		return exitFailed
+	}
+	var rawIds []string
+	for _, id := range personIds {
+		for _, hit := range s3Results.Hits(id) {
+			if hit.Reason == file.RawArchiveToAnalyze {
+				rawIds = append(rawIds, hit.Info.Ids()...)
+			}
+		}
+	}
+	if err := c.collectRawArchives(ctx, conn, rawIds); err != nil {
+		log.Println("export err: ", err)
+		return exitFailed
+	}
+
+	if err := writeBundle(ctx, c, is, taskIds); err != nil {
+		log.Println("write bundle err: ", err)
+		return exitFailed
+	}
+	log.Println("export snapshot to: ", output)
+	return 0
+}
+
+func (c *snapshotCollector) collectRawArchives(ctx context.Context, repo db.SnapRepository, personIds []string) error {
+	persons, rejected, err := repo.QueryPerson(ctx, personIds)
+	if err != nil {
+		return err
+	}
+	c.rejected = append(c.rejected, rejected...)
+	var linkFaceIds []string
+	for _, p := range persons {
+		if firstSeen(c.persons, p.PersonId) {
+			c.m.Persons = append(c.m.Persons, p)
+		}
+		if p.LinkFaceId != "" {
+			linkFaceIds = append(linkFaceIds, p.LinkFaceId)
+		}
+	}
+	tracks, rejected, err := repo.QueryTrack(ctx, linkFaceIds)
+	if err != nil {
+		return err
+	}
+	c.rejected = append(c.rejected, rejected...)
+	for _, t := range tracks {
+		if firstSeen(c.tracks, t.SnapId) {
+			c.m.Tracks = append(c.m.Tracks, t)
+		}
+	}
+	return nil
+}
+
+//快照包布局: manifest.json, 各表 json, ids/ 下为原始 id 文件, s3/ 下为任务档案文件
+func writeBundle(ctx context.Context, c *snapshotCollector, is []file.IdStruct, taskIds []string) (err error) {
+	bundle, err := openBundle(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			bundle.Close()
+		}
+	}()
+	tables, err := db.MarshalSnapshot(&c.m)
+	if err != nil {
+		return err
+	}
+	manifest := db.Manifest{
+		Version:   db.SnapshotVersion,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Date:      date,
+		Tasks:     taskIds,
+		Rows:      make(map[string]int),
+		Rejected:  c.rejected,
+	}
+	for name, bs := range tables {
+		if err := bundle.WriteFile(name, bs); err != nil {
+			return err
+		}
+	}
+	manifest.Rows[db.TablePeopleTrack] = len(c.m.Tracks)
+	manifest.Rows[db.TableTrashArchive] = len(c.m.Trashes)
+	manifest.Rows[db.TableFaceSnap] = len(c.m.Faces)
+	manifest.Rows[db.TablePersonSnap] = len(c.m.Persons)
+	manifest.Rows[db.TableWorkTask] = len(c.m.Tasks)
+	manifest.Rows[db.TableDeviceInfo] = len(c.m.Devices)
+
+	for _, i := range is {
+		bs, err := ioutil.ReadFile(filepath.Join(dir, i.Name))
+		if err != nil {
+			return err
+		}
+		if err := bundle.WriteFile(path.Join("ids", i.Name), bs); err != nil {
+			return err
+		}
+		manifest.IdFiles = append(manifest.IdFiles, i.Name)
+	}
+
+	for _, task := range taskIds {
+		names, err := store.List(ctx, path.Join(task, "Archive")+"/")
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := copyArchive(ctx, bundle, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	bs, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := bundle.WriteFile(db.ManifestFile, bs); err != nil {
+		return err
+	}
+	return bundle.Close()
+}
+
+func copyArchive(ctx context.Context, bundle bundleWriter, name string) error {
+	rc, err := store.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	size, ok := file.Size(rc)
+	if !ok {
+		//大小未知时先写入临时文件
+		tmp, err := ioutil.TempFile("", "dytest-archive")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if size, err = io.Copy(tmp, rc); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = tmp
+	}
+	return bundle.Copy(path.Join("s3", name), r, size)
+}
+
+//打开离线快照, 快照包先解压到临时目录, 返回快照目录及清理函数
+func openSnapshot(name string) (string, func(), error) {
+	if _, ok := bundleExt(name); !ok {
+		return name, func() {}, nil
+	}
+	tmp, err := ioutil.TempDir("", "dytest-snapshot")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+	root, err := extractBundle(name, tmp)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return root, cleanup, nil
+}
+
+//解压快照包到 dest, 包内只有一个顶层目录时返回该目录
+func extractBundle(name string, dest string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		entry := path.Clean(header.Name)
+		if path.IsAbs(entry) || entry == ".." || strings.HasPrefix(entry, "../") {
+			return "", fmt.Errorf("invalid entry in bundle %s: %s", name, header.Name)
+		}
+		p := filepath.Join(dest, filepath.FromSlash(entry))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0777); err != nil {
+				return "", err
+			}
+		case tar.TypeReg:
+			if err := (dirBundle{root: dest}).Copy(entry, tr, header.Size); err != nil {
+				return "", err
+			}
+		}
+	}
+	fis, err := ioutil.ReadDir(dest)
+	if err != nil {
+		return "", err
+	}
+	if len(fis) == 1 && fis[0].IsDir() {
+		return filepath.Join(dest, fis[0].Name()), nil
+	}
+	return dest, nil
+}
+
+//快照包中的 ids/ 及 s3/ 在命令行未指定 -d 及 -s/-s3-endpoint 时作为数据目录及档案存储,
+//清单中的导出日期在未指定 -t 时作为分析日期
+func useBundleInputs(snapshot string, m *db.Memory) {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	if m.Date != "" && m.Date != date {
+		if explicit["t"] {
+			log.Printf("warning: -t %s differs from snapshot date %s, tasks are filtered by create time\n", date, m.Date)
+		} else {
+			log.Println("analyze snapshot date: ", m.Date)
+			date = m.Date
+		}
+	}
+	ids := filepath.Join(snapshot, "ids")
+	if fi, err := os.Stat(ids); err == nil && fi.IsDir() && !explicit["d"] {
+		log.Println("read ids from snapshot: ", ids)
+		dir = ids
+	}
+	s3 := filepath.Join(snapshot, "s3")
+	if fi, err := os.Stat(s3); err == nil && fi.IsDir() && !explicit["s"] && !explicit["s3-endpoint"] {
+		log.Println("read archives from snapshot: ", s3)
+		store = file.LocalStorage{Root: s3}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"dytest/db"
+	"dytest/file"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	m, err := db.LoadFixture("testdata/analyze.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	s3 := filepath.Join(root, "s3")
+	big := `[{"deviceNum": 1, "archiveNum": 1, "devices": ["dev1"], "archive": ["` + personId(4) + `"]}]`
+	writeArchives(t, s3, "task1", map[string]string{file.BigArchiveFile: big})
+	ids := filepath.Join(root, "ids")
+	if err := os.MkdirAll(ids, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(ids, "walk"), []byte(personId(4)+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"bundle", "bundle.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			date, store, dir = "2023-04-15", file.LocalStorage{Root: s3}, ids
+			output = filepath.Join(t.TempDir(), name)
+			c := newSnapshotCollector()
+			c.m = *m
+			rejected := db.RejectedRow{Query: "face", SnapId: faceId(3), Reason: "deviceid is NULL"}
+			c.rejected = []db.RejectedRow{rejected}
+			is := []file.IdStruct{{Name: "walk"}}
+			if err := writeBundle(context.Background(), c, is, []string{"task1"}); err != nil {
+				t.Fatal(err)
+			}
+
+			snapshot, cleanup, err := openSnapshot(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+			loaded, err := db.LoadSnapshot(snapshot, db.DefaultSchema())
+			if err != nil {
+				t.Fatal(err)
+			}
+			//清单中的任务列表优先于按创建时间过滤
+			tasks, _, err := loaded.QueryTask(context.Background(), "2023-04-15")
+			if err != nil || !reflect.DeepEqual(tasks, []string{"task1"}) {
+				t.Errorf("tasks = %v, %v, want [task1]", tasks, err)
+			}
+			//导出时的问题行随快照包回放
+			_, quality, err := loaded.QueryFace(context.Background(), []string{faceId(3)})
+			if err != nil || !reflect.DeepEqual(quality, []db.RejectedRow{rejected}) {
+				t.Errorf("rejected rows = %v, %v, want %v", quality, err, rejected)
+			}
+			bs, err := ioutil.ReadFile(filepath.Join(snapshot, "s3", "task1", "Archive", file.BigArchiveFile))
+			if err != nil || string(bs) != big {
+				t.Errorf("archive in bundle = %q, %v, want %q", bs, err, big)
+			}
+			if _, err := os.Stat(filepath.Join(snapshot, "ids", "walk")); err != nil {
+				t.Error(err)
+			}
+			//未指定 -t 时回放快照包的导出日期及其中的 id 文件
+			date = "2000-01-01"
+			useBundleInputs(snapshot, loaded)
+			if date != "2023-04-15" || dir != filepath.Join(snapshot, "ids") {
+				t.Errorf("date = %s, dir = %s, want inputs from the bundle", date, dir)
+			}
+		})
+	}
+}
+
+func TestLoadSnapshotVersion(t *testing.T) {
+	snapshot := t.TempDir()
+	manifest := `{"version": 99, "date": "2023-04-15", "tasks": ["task1"]}`
+	if err := ioutil.WriteFile(filepath.Join(snapshot, db.ManifestFile), []byte(manifest), 0666); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.LoadSnapshot(snapshot, db.DefaultSchema())
+	if err == nil || !strings.Contains(err.Error(), "unsupported snapshot version 99") {
+		t.Errorf("load snapshot err = %v, want unsupported version", err)
+	}
+}
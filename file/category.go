@@ -0,0 +1,123 @@
+package file
+
+import (
+	"context"
+	"dytest/db"
+	"fmt"
+	"io"
+	"sync"
+)
+
+//命中档案后的进一步分析, 返回最终的丢弃原因
+type Analyzer func(ctx context.Context, repo db.SnapRepository, hit ArchiveHit) (string, []db.RejectedRow, error)
+
+//档案类别, 对应任务 Archive 目录下的一个文件
+type Category struct {
+	//档案文件名, 同时作为类别名
+	File string
+	//抓拍命中该类别档案时的丢弃原因
+	Reason string
+	//解析档案文件, 只保留与 filter 匹配的档案
+	Decode func(r io.Reader, filter IdFilter) ([]IdListable, error)
+	//命中后的进一步分析, 为 nil 时以 Reason 作为丢弃原因
+	Analyze Analyzer
+}
+
+//档案文件为 T 组成的 json 数组的类别
+func NewCategory[T IdListable](file string, reason string, analyze Analyzer) Category {
+	return Category{
+		File:    file,
+		Reason:  reason,
+		Decode:  decodeArchives[T],
+		Analyze: analyze,
+	}
+}
+
+var (
+	categoriesMu sync.RWMutex
+	categories   []Category
+)
+
+//注册档案类别, 注册顺序即默认优先级及同一任务内的命中顺序, 重复注册时 panic
+func Register(c Category) {
+	categoriesMu.Lock()
+	defer categoriesMu.Unlock()
+	if c.File == "" || c.Decode == nil {
+		panic("file: Register category without file name or decoder")
+	}
+	for _, r := range categories {
+		if r.File == c.File {
+			panic(fmt.Sprintf("file: Register called twice for category %s", c.File))
+		}
+	}
+	categories = append(categories, c)
+}
+
+//按注册顺序返回所有档案类别
+func Categories() []Category {
+	categoriesMu.RLock()
+	defer categoriesMu.RUnlock()
+	return append([]Category{}, categories...)
+}
+
+func Lookup(file string) (Category, bool) {
+	categoriesMu.RLock()
+	defer categoriesMu.RUnlock()
+	for _, c := range categories {
+		if c.File == file {
+			return c, true
+		}
+	}
+	return Category{}, false
+}
+
+func IsCategory(name string) bool {
+	_, ok := Lookup(name)
+	return ok
+}
+
+//默认的档案类别优先级, 抓拍命中多个档案时以优先级最高的作为丢弃原因
+func DefaultPrecedence() []string {
+	var names []string
+	for _, c := range Categories() {
+		names = append(names, c.File)
+	}
+	return names
+}
+
+func init() {
+	Register(NewCategory[SingleArchive](SingleArchiveFile, SingleArchiveTrash, nil))
+	Register(NewCategory[BigArchive](BigArchiveFile, BigArchiveTrash, nil))
+	Register(NewCategory[NolinkArchive](NoLinkArchiveFile, NoLinkArchiveTrash, nil))
+	Register(NewCategory[UnlinkArchive](UnLinkArchiveFile, UnLinkArchiveTrash, nil))
+	Register(NewCategory[SplitArchive](SplitArchiveFile, SplitArchiveTrash, nil))
+	Register(NewCategory[RawArchive](RawArchiveFile, RawArchiveToAnalyze, analyzeRawArchive))
+}
+
+//初始档案内的人体没有关联人脸时为无关联档案, 关联人脸均无轨迹时为关联人脸未入档
+func analyzeRawArchive(ctx context.Context, repo db.SnapRepository, hit ArchiveHit) (string, []db.RejectedRow, error) {
+	var rejected []db.RejectedRow
+	personInfos, r, err := repo.QueryPerson(ctx, hit.Info.Ids())
+	if err != nil {
+		return "", rejected, err
+	}
+	rejected = append(rejected, r...)
+	linkFaceIds := make([]string, 0)
+	for _, person := range personInfos {
+		if person.LinkFaceId != "" {
+			linkFaceIds = append(linkFaceIds, person.LinkFaceId)
+		}
+	}
+	if len(linkFaceIds) == 0 {
+		return NoLinkArchiveTrash, rejected, nil
+	}
+	t, r, err := repo.QueryTrack(ctx, linkFaceIds)
+	if err != nil {
+		return "", rejected, err
+	}
+	rejected = append(rejected, r...)
+	if len(t) == 0 {
+		return UnLinkArchiveTrash, rejected, nil
+	}
+	return RawArchiveToAnalyze, rejected, nil
+}
@@ -0,0 +1,60 @@
+package file
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+//压缩后的档案文件名后缀及解压方式, 按顺序查找, 未压缩的文件优先
+var compressions = []struct {
+	ext        string
+	decompress func(r io.Reader) (io.ReadCloser, error)
+}{
+	{"", func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(r), nil }},
+	{".gz", func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }},
+	{".zst", func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}},
+}
+
+type decompressReader struct {
+	io.ReadCloser
+	raw io.Closer
+}
+
+func (d decompressReader) Close() error {
+	err := d.ReadCloser.Close()
+	if rerr := d.raw.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+//打开档案文件或其压缩文件, 返回解压后的内容及实际读取的文件名, 都不存在时返回 fs.ErrNotExist
+func openArchive(ctx context.Context, store Storage, name string) (io.ReadCloser, string, error) {
+	for _, c := range compressions {
+		rc, err := store.Open(ctx, name+c.ext)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, name + c.ext, err
+		}
+		d, err := c.decompress(rc)
+		if err != nil {
+			rc.Close()
+			return nil, name + c.ext, err
+		}
+		return decompressReader{ReadCloser: d, raw: rc}, name + c.ext, nil
+	}
+	return nil, name, fs.ErrNotExist
+}
@@ -0,0 +1,121 @@
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+//记录打开的文件, 并统计未关闭的文件数
+type trackingStorage struct {
+	LocalStorage
+	opened []string
+	open   int
+}
+
+type trackingCloser struct {
+	io.ReadCloser
+	s *trackingStorage
+}
+
+func (c trackingCloser) Close() error {
+	c.s.open--
+	return c.ReadCloser.Close()
+}
+
+func (s *trackingStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := s.LocalStorage.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	s.opened = append(s.opened, name)
+	s.open++
+	return trackingCloser{ReadCloser: rc, s: s}, nil
+}
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll([]byte(s), nil)
+}
+
+func TestReadTaskResultCompressed(t *testing.T) {
+	root := t.TempDir()
+	files := map[string][]byte{
+		"gz/Archive/Big-Archive.gz":      gzipBytes(t, `[{"deviceNum": 1, "archiveNum": 1, "devices": ["dev1"], "archive": ["p1"]}]`),
+		"zst/Archive/Single-Archive.zst": zstdBytes(t, `["p2"]`),
+		//未压缩的文件优先于压缩文件
+		"plain/Archive/Single-Archive":    []byte(`["p3"]`),
+		"plain/Archive/Single-Archive.gz": gzipBytes(t, `["p4"]`),
+		"bad/Archive/Big-Archive.gz":      []byte("not gzip"),
+		"bad/Archive/Single-Archive.zst":  zstdBytes(t, `["p5"`),
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, content, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := &trackingStorage{LocalStorage: LocalStorage{Root: root}}
+	r, err := ReadTaskResult(context.Background(), store, []string{"gz", "zst", "plain", "bad"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits := map[string]string{"p1": "gz/" + BigArchiveFile, "p2": "zst/" + SingleArchiveFile, "p3": "plain/" + SingleArchiveFile}
+	for id, want := range hits {
+		h := r.Hits(id)
+		if len(h) != 1 || h[0].Task+"/"+h[0].Category != want {
+			t.Errorf("hits(%s) = %+v, want %s", id, h, want)
+		}
+	}
+	if h := r.Hits("p4"); len(h) != 0 {
+		t.Errorf("hits(p4) = %+v, want none from the shadowed .gz file", h)
+	}
+
+	var failed []string
+	for _, e := range r.Errors {
+		failed = append(failed, e.Task+"/"+e.File)
+	}
+	sort.Strings(failed)
+	if want := []string{"bad/Big-Archive.gz", "bad/Single-Archive.zst"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("archive errors = %v, want %v", failed, want)
+	}
+	for _, name := range store.opened {
+		if name == "plain/Archive/Single-Archive.gz" {
+			t.Errorf("opened %s although the uncompressed file exists", name)
+		}
+	}
+	if store.open != 0 {
+		t.Errorf("%d files left open, opened %v", store.open, store.opened)
+	}
+}
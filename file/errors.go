@@ -0,0 +1,29 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//读取或解析任务档案文件失败
+type ArchiveError struct {
+	Task string
+	File string
+	Err  error
+}
+
+func (e *ArchiveError) Error() string {
+	return fmt.Sprintf("read archive %s/%s err: %v", e.Task, e.File, e.Err)
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ArchiveError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Task  string `json:"task"`
+		File  string `json:"file"`
+		Error string `json:"error"`
+	}{e.Task, e.File, e.Err.Error()})
+}
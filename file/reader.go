@@ -1,11 +1,15 @@
 package file
 
 import (
-	"dytest/utils"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
-	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"unicode"
@@ -89,13 +93,10 @@ const (
 )
 
 type S3Result struct {
-	Id             string
-	BigArchives    []BigArchive
-	SingleArchive  []SingleArchive
-	UnlinkArchives []UnlinkArchive
-	NolinkArchives []NolinkArchive
-	SplitArchives  []SplitArchive
-	RawArchives    []RawArchive
+	Id string
+	//各类别文件中的档案, 以类别文件名为键
+	Archives map[string][]IdListable
+	index    map[string][]ArchiveHit
 }
 
 type IdListable interface {
@@ -146,105 +147,197 @@ type RawArchive struct {
 	UnlinkArchive
 }
 
-func (r S3Result) TrashInfo(id string) (string, IdListable) {
-	for _, s := range r.SingleArchive {
-		if string(s) == id {
-			return SingleArchiveTrash, s
-		}
+//档案类别, 与任务 Archive 目录下的文件名一致
+const (
+	BigArchiveFile    = "Big-Archive"
+	SingleArchiveFile = "Single-Archive"
+	NoLinkArchiveFile = "No-Linked-Archive"
+	UnLinkArchiveFile = "Un-Linked-Archive"
+	SplitArchiveFile  = "Split-Archive"
+	RawArchiveFile    = "Raw-Archive"
+)
+
+//抓拍在任务档案中的命中信息
+type ArchiveHit struct {
+	Task     string     `json:"task"`
+	Category string     `json:"category"`
+	Reason   string     `json:"reason"`
+	Info     IdListable `json:"info"`
+}
+
+//json 中额外输出档案内的抓拍 id, 不同类别的 info 结构不同
+func (h ArchiveHit) MarshalJSON() ([]byte, error) {
+	type plain ArchiveHit
+	var ids []string
+	if h.Info != nil {
+		ids = h.Info.Ids()
 	}
+	return json.Marshal(struct {
+		plain
+		Ids []string `json:"ids"`
+	}{plain(h), ids})
+}
 
-	for _, bigArchive := range r.BigArchives {
-		if utils.IsIn(bigArchive.Tracks, id) {
-			return BigArchiveTrash, bigArchive
+//按类别优先级选出主要命中, 优先级相同时取任务顺序靠前的, 未列出的类别排在最后
+func Headline(hits []ArchiveHit, precedence []string) (ArchiveHit, bool) {
+	if len(hits) == 0 {
+		return ArchiveHit{Reason: NotFound}, false
+	}
+	rank := func(category string) int {
+		for i, c := range precedence {
+			if c == category {
+				return i
+			}
+		}
+		return len(precedence)
+	}
+	best := hits[0]
+	for _, h := range hits[1:] {
+		if rank(h.Category) < rank(best.Category) {
+			best = h
 		}
 	}
-	for _, noLinkeArchive := range r.NolinkArchives {
-		if utils.IsIn(noLinkeArchive.PersonIds, id) {
-			return NoLinkArchiveTrash, noLinkeArchive
+	return best, true
+}
+
+//建立抓拍 id 到档案的索引, 同一任务内按类别注册顺序排列
+func (r *S3Result) buildIndex() {
+	r.index = make(map[string][]ArchiveHit)
+	for _, c := range Categories() {
+		for _, info := range r.Archives[c.File] {
+			for _, id := range info.Ids() {
+				r.index[id] = append(r.index[id], ArchiveHit{Task: r.Id, Category: c.File, Reason: c.Reason, Info: info})
+			}
 		}
 	}
-	for _, unLinkArchive := range r.UnlinkArchives {
-		if utils.IsIn(unLinkArchive.PersonIds, id) {
-			return UnLinkArchiveTrash, unLinkArchive
+}
+
+//多个任务的档案结果及其索引
+type TaskResults struct {
+	Results []S3Result
+	//非严格模式下无法读取或解析的档案文件
+	Errors []*ArchiveError
+	index  map[string][]ArchiveHit
+}
+
+func newTaskResults(results []S3Result) TaskResults {
+	t := TaskResults{Results: results, index: make(map[string][]ArchiveHit)}
+	for _, r := range results {
+		for id, hits := range r.index {
+			t.index[id] = append(t.index[id], hits...)
 		}
 	}
-	for _, splitArchive := range r.SplitArchives {
-		if utils.IsIn(splitArchive.Tracks, id) {
-			return SplitArchiveTrash, splitArchive
+	return t
+}
+
+//返回所有任务中命中的档案
+func (t TaskResults) Hits(id string) []ArchiveHit {
+	return t.index[id]
+}
+
+//待查找的抓拍 id, 为 nil 时保留所有档案
+type IdFilter map[string]struct{}
+
+func NewIdFilter(ids []string) IdFilter {
+	f := make(IdFilter, len(ids))
+	for _, id := range ids {
+		f[id] = struct{}{}
+	}
+	return f
+}
+
+//档案中包含任一待查找的抓拍
+func (f IdFilter) Match(info IdListable) bool {
+	if f == nil {
+		return true
+	}
+	for _, id := range info.Ids() {
+		if _, ok := f[id]; ok {
+			return true
 		}
 	}
+	return false
+}
 
-	for _, rawArchive := range r.RawArchives {
-		if utils.IsIn(rawArchive.PersonIds, id) {
-			return RawArchiveToAnalyze, rawArchive
+//逐个解析档案数组中的元素, 只保留与 filter 匹配的档案, 内存占用与文件大小无关
+func decodeArchives[T IdListable](r io.Reader, filter IdFilter) ([]IdListable, error) {
+	var out []IdListable
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return out, err
+	}
+	if tok == nil {
+		return out, nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return out, fmt.Errorf("expect json array, got %v", tok)
+	}
+	for dec.More() {
+		var a T
+		if err := dec.Decode(&a); err != nil {
+			return out, err
+		}
+		if filter.Match(a) {
+			out = append(out, a)
 		}
 	}
+	_, err = dec.Token()
+	return out, err
+}
 
-	return NotFound, nil
+//读取任务目录 Archive 下的档案文件, 支持 gzip 及 zstd 压缩, 文件不存在时跳过
+func readArchive(ctx context.Context, store Storage, task string, name string, decode func(io.Reader) error) error {
+	rc, p, err := openArchive(ctx, store, path.Join(task, "Archive", name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return &ArchiveError{Task: task, File: path.Base(p), Err: err}
+	}
+	defer rc.Close()
+	if err := decode(rc); err != nil {
+		return &ArchiveError{Task: task, File: path.Base(p), Err: err}
+	}
+	return nil
 }
 
-func ReadTaskResult(root string, tasks []string) ([]S3Result, error) {
+//读取各任务的档案文件, 只保留包含 filter 中抓拍的档案. strict 为 true 时遇到无法读取或解析的文件立即返回错误,
+//否则记录到 TaskResults.Errors 后继续, 该文件中的档案可能缺失
+func ReadTaskResult(ctx context.Context, store Storage, tasks []string, filter IdFilter, strict bool) (TaskResults, error) {
 	result := make([]S3Result, 0)
+	var archiveErrors []*ArchiveError
 
 	for _, workTask := range tasks {
-		var r = S3Result{Id: workTask}
-		bigArchivePath := filepath.Join(root, r.Id, "Archive", "Big-Archive")
-		b, err := ioutil.ReadFile(bigArchivePath)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				log.Println("read big archive error: ", bigArchivePath, err)
-			}
-		} else {
-			json.Unmarshal(b, &r.BigArchives)
+		if err := ctx.Err(); err != nil {
+			return TaskResults{}, err
 		}
-		singleArchivePath := filepath.Join(root, r.Id, "Archive", "Single-Archive")
-		b, err = ioutil.ReadFile(singleArchivePath)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				log.Println("read single archive error: ", singleArchivePath, err)
+		var r = S3Result{Id: workTask, Archives: make(map[string][]IdListable)}
+		for _, c := range Categories() {
+			err := readArchive(ctx, store, r.Id, c.File, func(rd io.Reader) error {
+				archives, err := c.Decode(rd, filter)
+				r.Archives[c.File] = archives
+				return err
+			})
+			if err == nil {
+				continue
 			}
-		} else {
-			json.Unmarshal(b, &r.SingleArchive)
-		}
-		noLinkArchivePath := filepath.Join(root, r.Id, "Archive", "No-Linked-Archive")
-		b, err = ioutil.ReadFile(noLinkArchivePath)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				log.Println("read no link archive error: ", noLinkArchivePath, err)
+			if ctx.Err() != nil {
+				return TaskResults{}, ctx.Err()
 			}
-		} else {
-			json.Unmarshal(b, &r.NolinkArchives)
-		}
-		unLinkArchivePath := filepath.Join(root, r.Id, "Archive", "Un-Linked-Archive")
-		b, err = ioutil.ReadFile(unLinkArchivePath)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				log.Println("read un link archive error: ", unLinkArchivePath, err)
+			var ae *ArchiveError
+			if !errors.As(err, &ae) || strict {
+				return TaskResults{}, err
 			}
-		} else {
-			json.Unmarshal(b, &r.UnlinkArchives)
-		}
-		splitArchivePath := filepath.Join(root, r.Id, "Archive", "Split-Archive")
-		b, err = ioutil.ReadFile(splitArchivePath)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				log.Println("read split archive error: ", splitArchivePath, err)
-			}
-		} else {
-			json.Unmarshal(b, &r.SplitArchives)
-		}
-		rawArchivePath := filepath.Join(root, r.Id, "Archive", "Raw-Archive")
-		b, err = ioutil.ReadFile(rawArchivePath)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				log.Println("read raw archive error: ", rawArchivePath, err)
-			}
-		} else {
-			json.Unmarshal(b, &r.RawArchives)
+			log.Println(err)
+			archiveErrors = append(archiveErrors, ae)
 		}
+		r.buildIndex()
 		result = append(result, r)
 	}
-	return result, nil
+	t := newTaskResults(result)
+	t.Errors = archiveErrors
+	return t, nil
 }
 
 func processRawFile(file string) []string {
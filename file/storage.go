@@ -0,0 +1,64 @@
+package file
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//任务档案文件的存储, 对象名以 / 分隔, 如 <task>/Archive/Big-Archive
+type Storage interface {
+	//读取对象, 对象不存在时返回的错误满足 errors.Is(err, fs.ErrNotExist)
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	//列出以 prefix 开头的对象名
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+//本地目录, 运行在 MinIO 所在主机时直接读取磁盘
+type LocalStorage struct {
+	Root string
+}
+
+func (l LocalStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.Root, filepath.FromSlash(name)))
+}
+
+//只列出 prefix 所在目录下的文件, 不递归子目录
+func (l LocalStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	dir, _ := path.Split(prefix)
+	fis, err := ioutil.ReadDir(filepath.Join(l.Root, filepath.FromSlash(dir)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, fi := range fis {
+		name := dir + fi.Name()
+		if !fi.IsDir() && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+//返回 Open 读取的对象大小, 本地文件取文件大小, S3 对象取 Content-Length, 无法得知时返回 false
+func Size(rc io.ReadCloser) (int64, bool) {
+	switch r := rc.(type) {
+	case interface{ Size() int64 }:
+		return r.Size(), true
+	case interface{ Stat() (fs.FileInfo, error) }:
+		fi, err := r.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return 0, false
+		}
+		return fi.Size(), true
+	}
+	return 0, false
+}
@@ -3,7 +3,10 @@ module dytest
 go 1.18
 
 require (
+	github.com/klauspost/compress v1.16.7
 	github.com/lib/pq v1.10.7
+	golang.org/x/term v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/gorm v1.24.7-0.20230306060331-85eaf9eeda11
 )
 
@@ -23,7 +26,7 @@ require (
 	github.com/joeshaw/multierror v0.0.0-20140124173710-69b34d4ec901 // indirect
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/prometheus/procfs v0.0.0-20190425082905-87a4384529e0 // indirect
-	github.com/vertica/vertica-sql-go v1.3.2 // indirect
+	github.com/vertica/vertica-sql-go v1.3.2
 	golang.org/x/sys v0.5.0 // indirect
 	gorm.io/driver/postgres v1.5.0
 	howett.net/plist v0.0.0-20181124034731-591f970eefbb // indirect
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"dytest/file"
+	"dytest/utils"
+	"embed"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed templates/report.html
+var templateFS embed.FS
+
+var reportTemplate = template.Must(template.New("report.html").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).ParseFS(templateFS, "templates/report.html"))
+
+//图表中的一项, Percent 为相对最大项的比例, 用于条形宽度
+type countItem struct {
+	Name    string
+	Count   int
+	Percent int
+}
+
+func newCountItems(counts map[string]int) []countItem {
+	items := make([]countItem, 0, len(counts))
+	max := 0
+	for name, c := range counts {
+		items = append(items, countItem{Name: name, Count: c})
+		if c > max {
+			max = c
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Name < items[j].Name
+	})
+	for i := range items {
+		items[i].Percent = items[i].Count * 100 / max
+	}
+	return items
+}
+
+//设备上的抓拍数
+type deviceCount struct {
+	DeviceId string
+	Face     int
+	Person   int
+	Archived bool
+}
+
+type snapRow struct {
+	SnapOutcome
+	ImageUrl string
+	//人体抓拍命中的所有档案
+	Hits []hitRow
+}
+
+type hitRow struct {
+	Task     string
+	Category string
+	Reason   string
+	Ids      []string
+}
+
+type htmlReport struct {
+	Result        AnalyzeResult
+	Date          string
+	GeneratedAt   string
+	Thumbnails    bool
+	DeviceNum     int
+	ArchivedSnaps int
+	FaceReasons   []countItem
+	PersonReasons []countItem
+	Devices       []deviceCount
+	Snaps         []snapRow
+}
+
+func newHTMLReport(r AnalyzeResult) htmlReport {
+	report := htmlReport{
+		Result:      r,
+		Date:        date,
+		GeneratedAt: time.Now().Format("2006-01-02 15:04:05"),
+		Thumbnails:  cfg.Output.Thumbnails,
+		DeviceNum:   len(utils.RemoveDeplicated(append(append([]string{}, r.SnapInfo.FaceDevices...), r.SnapInfo.PersonDevices...))),
+	}
+	hits := make(map[string][]hitRow)
+	for _, p := range r.PersonDiscard {
+		for _, h := range p.Hits {
+			var ids []string
+			if h.Info != nil {
+				ids = h.Info.Ids()
+			}
+			hits[p.Id] = append(hits[p.Id], hitRow{Task: h.Task, Category: h.Category, Reason: h.Reason, Ids: ids})
+		}
+	}
+	archived := make(map[string]bool)
+	for _, d := range r.DeviceIds {
+		archived[d] = true
+	}
+	faceReasons := make(map[string]int)
+	personReasons := make(map[string]int)
+	devices := make(map[string]*deviceCount)
+	for _, o := range r.SnapOutcomes() {
+		if o.PeopleId != "" {
+			report.ArchivedSnaps++
+		} else {
+			reason := o.DiscardReason
+			if reason == "" {
+				reason = file.NotFound
+			}
+			if o.Type == "face" {
+				faceReasons[reason]++
+			} else {
+				personReasons[reason]++
+			}
+		}
+		if o.DeviceId != "" {
+			d, ok := devices[o.DeviceId]
+			if !ok {
+				d = &deviceCount{DeviceId: o.DeviceId, Archived: archived[o.DeviceId]}
+				devices[o.DeviceId] = d
+			}
+			if o.Type == "face" {
+				d.Face++
+			} else {
+				d.Person++
+			}
+		}
+		report.Snaps = append(report.Snaps, snapRow{SnapOutcome: o, ImageUrl: imageUrl(r.SnapImages[o.SnapId]), Hits: hits[o.SnapId]})
+	}
+	report.FaceReasons = newCountItems(faceReasons)
+	report.PersonReasons = newCountItems(personReasons)
+	for _, d := range devices {
+		report.Devices = append(report.Devices, *d)
+	}
+	sort.Slice(report.Devices, func(i, j int) bool {
+		a, b := report.Devices[i], report.Devices[j]
+		if a.Face+a.Person != b.Face+b.Person {
+			return a.Face+a.Person > b.Face+b.Person
+		}
+		return a.DeviceId < b.DeviceId
+	})
+	return report
+}
+
+//相对路径的图片地址拼接配置的前缀
+func imageUrl(u string) string {
+	if u == "" || cfg.Output.ImageBaseUrl == "" || strings.Contains(u, "://") {
+		return u
+	}
+	return strings.TrimRight(cfg.Output.ImageBaseUrl, "/") + "/" + strings.TrimLeft(u, "/")
+}
+
+//输出单文件 html 报告, 样式内嵌, 不依赖外部资源
+func (r AnalyzeResult) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, newHTMLReport(r))
+}
@@ -1,136 +1,216 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"dytest/config"
 	"dytest/db"
 	"dytest/file"
 	"dytest/utils"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
+	_ "time/tzdata"
 
 	_ "github.com/lib/pq"
 	_ "github.com/vertica/vertica-sql-go"
+	"golang.org/x/term"
 )
 
-//数据库连接信息
-
-type VerticaConnInfo struct {
-	host     string
-	port     int
-	user     string
-	password string
-}
+//生效的配置解析后写入以下变量
+var (
+	date  string
+	store file.Storage
+	dir   string
 
-type PgConnInfo struct {
-	host     string
-	port     int
-	user     string
-	password string
-}
+	offline string
+	output  string
 
-var (
-	date string
-	root string
-	dir  string
+	configPath string
+	profile    string
+	cfg        config.Profile
+)
 
-	vconn string
-	pconn string
+//退出码: 有文件分析失败, 超过总超时时间, 收到中断信号
+const (
+	exitFailed      = 1
+	exitTimeout     = 124
+	exitInterrupted = 130
 )
 
 type AnalyzeResult struct {
-	SnapInfo            SnapInfo
-	Name                string
-	DeviceIds           []string
-	PersonArchiveDevice []string
-	PeopleInfos         []PeopleInfo
-	FaceDiscards        []FaceDiscard
-	PersonDiscard       []PersonDiscard
+	SnapInfo            SnapInfo        `json:"snapInfo"`
+	Name                string          `json:"name"`
+	DeviceIds           []string        `json:"deviceIds"`
+	PersonArchiveDevice []string        `json:"personArchiveDevice"`
+	PeopleInfos         []PeopleInfo    `json:"peopleInfos"`
+	FaceDiscards        []FaceDiscard   `json:"faceDiscards"`
+	PersonDiscard       []PersonDiscard `json:"personDiscard"`
+	//既不是人脸也不是人体抓拍的 id
+	InvalidIds []string `json:"invalidIds,omitempty"`
+	//输入的抓拍 id 及抓拍所在设备和图片地址, 用于输出逐条抓拍的结果
+	FaceIds     []string          `json:"-"`
+	PersonIds   []string          `json:"-"`
+	SnapDevices map[string]string `json:"-"`
+	SnapImages  map[string]string `json:"-"`
+	//数据库中无法读取或部分列为空的数据行
+	DataQuality []db.RejectedRow `json:"dataQuality,omitempty"`
+	//无法读取或解析的任务档案文件, 对应任务的丢弃原因可能不准确
+	ArchiveErrors []*file.ArchiveError `json:"archiveErrors,omitempty"`
+	//分析失败时的错误, 结果中只包含失败前已完成的部分
+	Err error `json:"-"`
+}
+
+//以文本格式写入结果, 返回第一个写入错误
+func (r AnalyzeResult) Write(w io.Writer) error {
+	ew := &errWriter{w: w}
+	r.write(ew)
+	return ew.err
 }
 
-func (r AnalyzeResult) Write(writer *os.File) {
-	log.Println("start to write result to file: ", r.Name)
-	writer.WriteString("该走点人走点基本信息如下: \n")
-	writer.WriteString(fmt.Sprintf("-设备数: %d, 人脸设备: %d, 人体设备: %d\n",
+func (r AnalyzeResult) write(writer *errWriter) {
+	if r.Err != nil {
+		writer.printf("分析失败, 以下结果不完整: %v\n", r.Err)
+	}
+	writer.print("该走点人走点基本信息如下: \n")
+	writer.printf("-设备数: %d, 人脸设备: %d, 人体设备: %d\n",
 		len(utils.RemoveDeplicated(append(r.SnapInfo.FaceDevices, r.SnapInfo.PersonDevices...))),
-		len(r.SnapInfo.FaceDevices), len(r.SnapInfo.PersonDevices)))
-	writer.WriteString(fmt.Sprintf("-人脸抓拍数: %d\n", r.SnapInfo.FaceSnapNum))
-	writer.WriteString(fmt.Sprintf("-人体抓拍数: %d\n", r.SnapInfo.PersonSnapNum))
-
-	writer.WriteString("聚档信息如下: \n")
-	writer.WriteString(fmt.Sprintf("-召回设备数: %d\n", len(r.DeviceIds)))
-	writer.WriteString(fmt.Sprintf("-召回设备列表: %s\n", strings.Join(r.DeviceIds, ",")))
-	writer.WriteString(fmt.Sprintf("-档案数: %d\n", len(r.PeopleInfos)))
-	writer.WriteString("-档案详情: \n")
+		len(r.SnapInfo.FaceDevices), len(r.SnapInfo.PersonDevices))
+	writer.printf("-人脸抓拍数: %d\n", r.SnapInfo.FaceSnapNum)
+	writer.printf("-人体抓拍数: %d\n", r.SnapInfo.PersonSnapNum)
+
+	writer.print("聚档信息如下: \n")
+	writer.printf("-召回设备数: %d\n", len(r.DeviceIds))
+	writer.printf("-召回设备列表: %s\n", strings.Join(r.DeviceIds, ","))
+	writer.printf("-档案数: %d\n", len(r.PeopleInfos))
+	writer.print("-档案详情: \n")
 	for _, p := range r.PeopleInfos {
-		writer.WriteString("-------------------------------------\n")
-		p.Write(writer)
+		writer.print("-------------------------------------\n")
+		p.write(writer)
 	}
 
-	writer.WriteString("-------------------------------------\n")
-	writer.WriteString("-人脸丢弃信息: \n")
+	writer.print("-------------------------------------\n")
+	writer.print("-人脸丢弃信息: \n")
 	for _, f := range r.FaceDiscards {
-		f.Write(writer)
+		f.write(writer)
 	}
 
-	writer.WriteString("-人体丢弃信息: \n")
+	writer.print("-人体丢弃信息: \n")
 	for _, p := range r.PersonDiscard {
-		writer.WriteString("-------------------------------------\n")
-		p.Write(writer)
+		writer.print("-------------------------------------\n")
+		p.write(writer)
+	}
+
+	if len(r.DataQuality) > 0 {
+		writer.print("-------------------------------------\n")
+		writer.print("数据质量问题如下: \n")
+		counts := make(map[string]int)
+		var queries []string
+		for _, q := range r.DataQuality {
+			if _, ok := counts[q.Query]; !ok {
+				queries = append(queries, q.Query)
+			}
+			counts[q.Query]++
+		}
+		for _, q := range queries {
+			writer.printf("-检索: %s, 问题行数: %d\n", q, counts[q])
+		}
+		for _, q := range r.DataQuality {
+			writer.printf("|检索: %s, 抓拍: %s, 原因: %s\n", q.Query, q.SnapId, q.Reason)
+		}
+	}
+	if len(r.ArchiveErrors) > 0 {
+		writer.print("-------------------------------------\n")
+		writer.print("任务档案文件问题如下, 相关抓拍的丢弃原因可能不准确: \n")
+		for _, e := range r.ArchiveErrors {
+			writer.printf("|任务: %s, 文件: %s, 原因: %v\n", e.Task, e.File, e.Err)
+		}
 	}
-	log.Println("end write result: ", r.Name)
 }
 
 type SnapInfo struct {
-	FaceSnapNum   int
-	PersonSnapNum int
-	FaceDevices   []string
-	PersonDevices []string
+	FaceSnapNum   int      `json:"faceSnapNum"`
+	PersonSnapNum int      `json:"personSnapNum"`
+	FaceDevices   []string `json:"faceDevices"`
+	PersonDevices []string `json:"personDevices"`
 }
 
 type PeopleInfo struct {
-	PeopleId     string
-	DeviceIds    []string
-	PersonTracks []string
-	PersonDevice []string
-	FaceTracks   []string
-	FaceDevice   []string
+	PeopleId     string   `json:"peopleId"`
+	DeviceIds    []string `json:"deviceIds"`
+	PersonTracks []string `json:"personTracks"`
+	PersonDevice []string `json:"personDevice"`
+	FaceTracks   []string `json:"faceTracks"`
+	FaceDevice   []string `json:"faceDevice"`
+}
+
+func (p PeopleInfo) Write(w io.Writer) error {
+	ew := &errWriter{w: w}
+	p.write(ew)
+	return ew.err
 }
 
-func (p PeopleInfo) Write(writer *os.File) {
-	writer.WriteString(fmt.Sprintf("|档案ID: %s\n", p.PeopleId))
-	writer.WriteString(fmt.Sprintf("|设备数: %d, 人脸设备数: %d, 人体设备数: %d\n", len(p.DeviceIds), len(p.FaceDevice), len(p.PersonDevice)))
-	writer.WriteString(fmt.Sprintf("|人脸抓拍数: %d, 人体抓拍数: %d\n", len(p.FaceTracks), len(p.PersonTracks)))
-	writer.WriteString(fmt.Sprintf("|设备列表: %s\n", strings.Join(p.DeviceIds, ",")))
-	writer.WriteString(fmt.Sprintf("|人脸设备列表: %s\n", strings.Join(p.FaceDevice, ",")))
-	writer.WriteString(fmt.Sprintf("|人体设备列表: %s\n", strings.Join(p.PersonDevice, ",")))
+func (p PeopleInfo) write(writer *errWriter) {
+	writer.printf("|档案ID: %s\n", p.PeopleId)
+	writer.printf("|设备数: %d, 人脸设备数: %d, 人体设备数: %d\n", len(p.DeviceIds), len(p.FaceDevice), len(p.PersonDevice))
+	writer.printf("|人脸抓拍数: %d, 人体抓拍数: %d\n", len(p.FaceTracks), len(p.PersonTracks))
+	writer.printf("|设备列表: %s\n", strings.Join(p.DeviceIds, ","))
+	writer.printf("|人脸设备列表: %s\n", strings.Join(p.FaceDevice, ","))
+	writer.printf("|人体设备列表: %s\n", strings.Join(p.PersonDevice, ","))
 }
 
 type FaceDiscard struct {
-	DiscardReason string
-	Ids           []string
+	DiscardReason string   `json:"discardReason"`
+	Ids           []string `json:"ids"`
+}
+
+func (f FaceDiscard) Write(w io.Writer) error {
+	ew := &errWriter{w: w}
+	f.write(ew)
+	return ew.err
 }
 
-func (f FaceDiscard) Write(writer *os.File) {
-	writer.WriteString(fmt.Sprintf("|丢弃原因: %s, 数量: %d\n", f.DiscardReason, len(f.Ids)))
-	writer.WriteString(fmt.Sprintf("|丢弃抓拍: %s\n", strings.Join(f.Ids, ",")))
+func (f FaceDiscard) write(writer *errWriter) {
+	writer.printf("|丢弃原因: %s, 数量: %d\n", f.DiscardReason, len(f.Ids))
+	writer.printf("|丢弃抓拍: %s\n", strings.Join(f.Ids, ","))
 }
 
 type PersonDiscard struct {
-	Id                string
-	DeviceId          string
-	DiscardReason     string
-	WorkTask          string
-	PersonArchiveInfo interface{}
+	Id            string `json:"id"`
+	DeviceId      string `json:"deviceId"`
+	DiscardReason string `json:"discardReason"`
+	WorkTask      string `json:"workTask"`
+	//命中档案中优先级最高的, 未命中时为 nil
+	PersonArchiveInfo *file.ArchiveHit `json:"personArchiveInfo"`
+	//所有命中的档案
+	Hits []file.ArchiveHit `json:"hits,omitempty"`
 }
 
-func (p PersonDiscard) Write(writer *os.File) {
-	writer.WriteString(fmt.Sprintf("|任务: %s, 丢弃原因: %s, 人体抓拍: %s, 设备ID: %s\n", p.WorkTask, p.DiscardReason, p.Id, p.DeviceId))
-	writer.WriteString(fmt.Sprintf("|详情: %v\n", p.PersonArchiveInfo))
+func (p PersonDiscard) Write(w io.Writer) error {
+	ew := &errWriter{w: w}
+	p.write(ew)
+	return ew.err
+}
+
+func (p PersonDiscard) write(writer *errWriter) {
+	var info file.IdListable
+	if p.PersonArchiveInfo != nil {
+		info = p.PersonArchiveInfo.Info
+	}
+	writer.printf("|任务: %s, 丢弃原因: %s, 人体抓拍: %s, 设备ID: %s\n", p.WorkTask, p.DiscardReason, p.Id, p.DeviceId)
+	writer.printf("|详情: %v\n", info)
+	if len(p.Hits) > 1 {
+		writer.printf("|命中档案数: %d\n", len(p.Hits))
+		for _, h := range p.Hits {
+			writer.printf("|-任务: %s, 类别: %s, 详情: %v\n", h.Task, h.Reason, h.Info)
+		}
+	}
 }
 
 func (r *AnalyzeResult) clean() {
@@ -152,106 +232,174 @@ func (r *AnalyzeResult) personTrackIds() []string {
 	return ids
 }
 
-func analyze(conn *sql.DB, idStruct file.IdStruct) AnalyzeResult {
+//分析参数, 与数据源一起决定一次分析的结果
+type analyzeOptions struct {
+	//分析日期, 按该日期查询人体聚档任务
+	Date string
+	//聚档结果所在的存储
+	Store         file.Storage
+	Thresholds    config.Thresholds
+	Precedence    []string
+	StrictArchive bool
+}
+
+//由生效的配置得到分析参数
+func newAnalyzeOptions() analyzeOptions {
+	return analyzeOptions{
+		Date:          date,
+		Store:         store,
+		Thresholds:    cfg.Thresholds,
+		Precedence:    cfg.Precedence,
+		StrictArchive: cfg.StrictArchive,
+	}
+}
+
+func analyze(ctx context.Context, repo db.SnapRepository, archives db.ArchiveRepository,
+	opts analyzeOptions, idStruct file.IdStruct) AnalyzeResult {
 	log.Println("start to process: ", idStruct.Name)
 	result := AnalyzeResult{Name: idStruct.Name}
-	processSnapInfo(conn, idStruct, &result)
-	processTracks(conn, idStruct, &result)
-	processFaceTrash(conn, idStruct, &result)
-	processPersonTrash(idStruct, &result, conn)
+	if err := analyzeSteps(ctx, repo, archives, opts, idStruct, &result); err != nil {
+		log.Println("analyze file err: ", idStruct.Name, err)
+		result.Err = err
+	}
 	result.clean()
 	return result
 }
 
-func processSnapInfo(conn *sql.DB, idStruct file.IdStruct, result *AnalyzeResult) {
+func analyzeSteps(ctx context.Context, repo db.SnapRepository, archives db.ArchiveRepository,
+	opts analyzeOptions, idStruct file.IdStruct, result *AnalyzeResult) error {
+	if err := processSnapInfo(ctx, repo, idStruct, result); err != nil {
+		return err
+	}
+	if err := processTracks(ctx, repo, idStruct, result); err != nil {
+		return err
+	}
+	if err := processFaceTrash(ctx, repo, idStruct, result); err != nil {
+		return err
+	}
+	return processPersonTrash(ctx, opts, idStruct, result, repo, archives)
+}
+
+func processSnapInfo(ctx context.Context, repo db.SnapRepository, idStruct file.IdStruct, result *AnalyzeResult) error {
 	result.SnapInfo.FaceSnapNum = len(idStruct.FaceIds)
 	result.SnapInfo.PersonSnapNum = len(idStruct.PersonIds)
+	result.FaceIds, result.PersonIds = idStruct.FaceIds, idStruct.PersonIds
+	result.InvalidIds = idStruct.InvalidIds
+	result.SnapDevices = make(map[string]string)
+	result.SnapImages = make(map[string]string)
 	log.Println("process snap info, snap face num:", result.SnapInfo.FaceSnapNum, " snap person num: ", result.SnapInfo.PersonSnapNum)
-	fis := db.QueryFace(conn, idStruct.FaceIds)
+	fis, rejected, err := repo.QueryFace(ctx, idStruct.FaceIds)
+	if err != nil {
+		return err
+	}
+	result.DataQuality = append(result.DataQuality, rejected...)
 	for _, fi := range fis {
 		result.SnapInfo.FaceDevices = append(result.SnapInfo.FaceDevices, fi.DeviceId)
+		result.SnapDevices[fi.FaceId] = fi.DeviceId
+		result.SnapImages[fi.FaceId] = fi.ImageUrl
 	}
-	pis := db.QueryPerson(conn, idStruct.PersonIds)
+	pis, rejected, err := repo.QueryPerson(ctx, idStruct.PersonIds)
+	if err != nil {
+		return err
+	}
+	result.DataQuality = append(result.DataQuality, rejected...)
 	for _, pi := range pis {
 		result.SnapInfo.PersonDevices = append(result.SnapInfo.PersonDevices, pi.DeviceId)
+		result.SnapDevices[pi.PersonId] = pi.DeviceId
+		result.SnapImages[pi.PersonId] = pi.ImageUrl
 	}
+	return nil
 }
 
-func processPersonTrash(idStruct file.IdStruct, result *AnalyzeResult, conn *sql.DB) {
+func processPersonTrash(ctx context.Context, opts analyzeOptions, idStruct file.IdStruct, result *AnalyzeResult,
+	repo db.SnapRepository, archives db.ArchiveRepository) error {
 	log.Println("start to process person trash")
 	log.Println("person ids: {}", idStruct.PersonIds)
 	log.Println("person tracks: {}", result.personTrackIds())
 	personTrashIds := utils.Substract(idStruct.PersonIds, result.personTrackIds())
 	log.Println("person trash id: ", personTrashIds)
 	personDiscardMap := make(map[string]PersonDiscard)
-	pis := db.QueryPerson(conn, personTrashIds)
+	pis, rejected, err := repo.QueryPerson(ctx, personTrashIds)
+	if err != nil {
+		return err
+	}
+	result.DataQuality = append(result.DataQuality, rejected...)
 	for _, pi := range pis {
 		personDiscard := PersonDiscard{Id: pi.PersonId, DeviceId: pi.DeviceId}
-		if pi.Height < 150 || pi.Width < 60 {
+		if pi.Height < opts.Thresholds.MinPersonHeight || pi.Width < opts.Thresholds.MinPersonWidth {
 			personDiscard.DiscardReason = file.SmallSize
 		}
 		personDiscardMap[pi.PersonId] = personDiscard
 	}
-	d := db.Connect(db.PG, pconn)
-	tasks := db.QueryTask(d, date)
-	personArchived := db.QueryPersonArchiveIds(d, result.SnapInfo.PersonDevices)
+	tasks, rejected, err := archives.QueryTask(ctx, opts.Date)
+	if err != nil {
+		return err
+	}
+	result.DataQuality = append(result.DataQuality, rejected...)
+	personArchived, rejected, err := archives.QueryPersonArchiveIds(ctx, result.SnapInfo.PersonDevices)
+	if err != nil {
+		return err
+	}
+	result.DataQuality = append(result.DataQuality, rejected...)
 	personArchivedMap := make(map[string]struct{})
 	for _, dId := range personArchived {
 		personArchivedMap[dId] = struct{}{}
 	}
-	s3Results, err := file.ReadTaskResult(root, tasks)
-	if err == nil {
-		for k, v := range personDiscardMap {
-			if _, ok := personArchivedMap[v.DeviceId]; !ok {
-				v.DiscardReason = file.DeviceNotArchived
-				personDiscardMap[v.Id] = v
-			}
-			if v.DiscardReason == "" {
-				processDiscardReason(s3Results, v, personDiscardMap, k, conn)
-			}
+	var pending []string
+	for k, v := range personDiscardMap {
+		if _, ok := personArchivedMap[v.DeviceId]; !ok {
+			v.DiscardReason = file.DeviceNotArchived
+			personDiscardMap[k] = v
+		}
+		if v.DiscardReason == "" {
+			pending = append(pending, k)
+		}
+	}
+	s3Results, err := file.ReadTaskResult(ctx, opts.Store, tasks, file.NewIdFilter(pending), opts.StrictArchive)
+	if err != nil {
+		return err
+	}
+	result.ArchiveErrors = append(result.ArchiveErrors, s3Results.Errors...)
+	for _, k := range pending {
+		if err := processDiscardReason(ctx, s3Results, opts.Precedence, personDiscardMap[k], personDiscardMap, k, repo, result); err != nil {
+			return err
 		}
 	}
 	for _, d := range personDiscardMap {
 		result.PersonDiscard = append(result.PersonDiscard, d)
 	}
+	return nil
 }
 
-func processDiscardReason(s3Results []file.S3Result, v PersonDiscard,
-	personDiscardMap map[string]PersonDiscard, k string, conn *sql.DB) {
-	for _, r := range s3Results {
-		discardReason, info := r.TrashInfo(v.Id)
-		if discardReason == file.NotFound {
-			continue
-		}
-		v.DiscardReason = discardReason
-		v.WorkTask = r.Id
-		v.PersonArchiveInfo = info
-		personDiscardMap[k] = v
-		if discardReason == file.RawArchiveToAnalyze {
-			s := info.Ids()
-			personInfos := db.QueryPerson(conn, s)
-			linkFaceIds := make([]string, 0)
-			for _, person := range personInfos {
-				if person.LinkFaceId != "" {
-					linkFaceIds = append(linkFaceIds, person.LinkFaceId)
-				}
-			}
-			if len(linkFaceIds) == 0 {
-				v.DiscardReason = file.NoLinkArchiveTrash
-			} else {
-				t := db.QueryTrack(conn, linkFaceIds)
-				if len(t) == 0 {
-					v.DiscardReason = file.UnLinkArchiveTrash
-				}
-			}
+func processDiscardReason(ctx context.Context, s3Results file.TaskResults, precedence []string, v PersonDiscard,
+	personDiscardMap map[string]PersonDiscard, k string, repo db.SnapRepository, result *AnalyzeResult) error {
+	v.Hits = s3Results.Hits(v.Id)
+	hit, ok := file.Headline(v.Hits, precedence)
+	if !ok {
+		return nil
+	}
+	v.DiscardReason = hit.Reason
+	v.WorkTask = hit.Task
+	v.PersonArchiveInfo = &hit
+	if c, ok := file.Lookup(hit.Category); ok && c.Analyze != nil {
+		reason, rejected, err := c.Analyze(ctx, repo, hit)
+		result.DataQuality = append(result.DataQuality, rejected...)
+		if err != nil {
+			return err
 		}
-		return
+		v.DiscardReason = reason
 	}
+	personDiscardMap[k] = v
+	return nil
 }
 
-func processFaceTrash(conn *sql.DB, idStruct file.IdStruct, result *AnalyzeResult) {
+func processFaceTrash(ctx context.Context, repo db.SnapRepository, idStruct file.IdStruct, result *AnalyzeResult) error {
 	log.Println("start to process face trash")
-	trashes := db.QueryTrash(conn, idStruct.FaceIds)
+	trashes, rejected, err := repo.QueryTrash(ctx, idStruct.FaceIds)
+	if err != nil {
+		return err
+	}
+	result.DataQuality = append(result.DataQuality, rejected...)
 	trashMap := make(map[string][]db.Track)
 	for _, t := range trashes {
 		trashMap[t.DiscardInfo] = append(trashMap[t.DiscardInfo], t)
@@ -264,21 +412,34 @@ func processFaceTrash(conn *sql.DB, idStruct file.IdStruct, result *AnalyzeResul
 		}
 		result.FaceDiscards = append(result.FaceDiscards, faceDiscard)
 	}
+	return nil
 }
 
-func processTracks(conn *sql.DB, idStruct file.IdStruct, result *AnalyzeResult) {
+func processTracks(ctx context.Context, repo db.SnapRepository, idStruct file.IdStruct, result *AnalyzeResult) error {
 	log.Println("start to process tracks")
-	tracks := db.QueryTrack(conn, append(idStruct.FaceIds, idStruct.PersonIds...))
+	tracks, rejected, err := repo.QueryTrack(ctx, append(idStruct.FaceIds, idStruct.PersonIds...))
+	if err != nil {
+		return err
+	}
+	result.DataQuality = append(result.DataQuality, rejected...)
 	trackMap := make(map[string][]db.Track)
 	for _, t := range tracks {
 		trackMap[t.PeopleId] = append(trackMap[t.PeopleId], t)
 	}
+	faceIds := make(map[string]struct{}, len(idStruct.FaceIds))
+	for _, id := range idStruct.FaceIds {
+		faceIds[id] = struct{}{}
+	}
 	for k, v := range trackMap {
 		people := PeopleInfo{PeopleId: k}
 		for _, t := range v {
 			result.DeviceIds = append(result.DeviceIds, t.DeviceId)
 			people.DeviceIds = append(people.DeviceIds, t.DeviceId)
-			if t.TrackType == 0 {
+			isFace := t.TrackType == 0
+			if t.TrackType == db.TrackTypeUnknown {
+				_, isFace = faceIds[t.SnapId]
+			}
+			if isFace {
 				people.FaceDevice = append(people.FaceDevice, t.DeviceId)
 				people.FaceTracks = append(people.FaceTracks, t.SnapId)
 			} else {
@@ -289,57 +450,322 @@ func processTracks(conn *sql.DB, idStruct file.IdStruct, result *AnalyzeResult)
 		result.DeviceIds = utils.RemoveDeplicated(result.DeviceIds)
 		result.PeopleInfos = append(result.PeopleInfos, people)
 	}
+	return nil
 }
 
-//解析命令行参数
-func parseArgs() {
-	var pgConnInfo PgConnInfo
-	var verticaConnInfo VerticaConnInfo
-	flag.StringVar(&verticaConnInfo.user, "u", "dbadmin", "MPP数据库用户名")
-	flag.StringVar(&verticaConnInfo.password, "a", "passwd", "MPP数据库密码")
-	flag.IntVar(&verticaConnInfo.port, "p", 5433, "MPP数据库端口(5433)")
-	flag.StringVar(&verticaConnInfo.host, "h", "152.9.10.34", "MPP数据库服务IP")
+//命令行参数对应的配置项, 仅显式指定的参数覆盖配置
+var flagFields = map[string]func(c *config.Profile, f config.Profile){
+	"u":              func(c *config.Profile, f config.Profile) { c.Vertica.User = f.Vertica.User },
+	"a":              func(c *config.Profile, f config.Profile) { c.Vertica.Password = f.Vertica.Password },
+	"p":              func(c *config.Profile, f config.Profile) { c.Vertica.Port = f.Vertica.Port },
+	"h":              func(c *config.Profile, f config.Profile) { c.Vertica.Host = f.Vertica.Host },
+	"U":              func(c *config.Profile, f config.Profile) { c.PG.User = f.PG.User },
+	"A":              func(c *config.Profile, f config.Profile) { c.PG.Password = f.PG.Password },
+	"P":              func(c *config.Profile, f config.Profile) { c.PG.Port = f.PG.Port },
+	"H":              func(c *config.Profile, f config.Profile) { c.PG.Host = f.PG.Host },
+	"t":              func(c *config.Profile, f config.Profile) { c.Date = f.Date },
+	"tz":             func(c *config.Profile, f config.Profile) { c.TimeZone = f.TimeZone },
+	"s":              func(c *config.Profile, f config.Profile) { c.S3Root = f.S3Root },
+	"s3-endpoint":    func(c *config.Profile, f config.Profile) { c.S3.Endpoint = f.S3.Endpoint },
+	"s3-bucket":      func(c *config.Profile, f config.Profile) { c.S3.Bucket = f.S3.Bucket },
+	"s3-prefix":      func(c *config.Profile, f config.Profile) { c.S3.Prefix = f.S3.Prefix },
+	"d":              func(c *config.Profile, f config.Profile) { c.DataDir = f.DataDir },
+	"batch":          func(c *config.Profile, f config.Profile) { c.Query.BatchSize = f.Query.BatchSize },
+	"batch-parallel": func(c *config.Profile, f config.Profile) { c.Query.BatchParallel = f.Query.BatchParallel },
+	"timeout":        func(c *config.Profile, f config.Profile) { c.Query.Timeout = f.Query.Timeout },
+	"query-timeout":  func(c *config.Profile, f config.Profile) { c.Query.QueryTimeout = f.Query.QueryTimeout },
+	"min-width":      func(c *config.Profile, f config.Profile) { c.Thresholds.MinPersonWidth = f.Thresholds.MinPersonWidth },
+	"min-height":     func(c *config.Profile, f config.Profile) { c.Thresholds.MinPersonHeight = f.Thresholds.MinPersonHeight },
+	"out-dir":        func(c *config.Profile, f config.Profile) { c.Output.Dir = f.Output.Dir },
+	"format":         func(c *config.Profile, f config.Profile) { c.Output.Format = f.Output.Format },
+	"table":          func(c *config.Profile, f config.Profile) { c.Output.Table = f.Output.Table },
+	"thumbnails":     func(c *config.Profile, f config.Profile) { c.Output.Thumbnails = f.Output.Thumbnails },
+	"image-base":     func(c *config.Profile, f config.Profile) { c.Output.ImageBaseUrl = f.Output.ImageBaseUrl },
+	"xlsx":           func(c *config.Profile, f config.Profile) { c.Output.Xlsx = f.Output.Xlsx },
+	"workers":        func(c *config.Profile, f config.Profile) { c.Workers = f.Workers },
+	"progress":       func(c *config.Profile, f config.Profile) { c.Progress = f.Progress },
+	"precedence":     func(c *config.Profile, f config.Profile) { c.Precedence = f.Precedence },
+	"strict-archive": func(c *config.Profile, f config.Profile) { c.StrictArchive = f.StrictArchive },
+}
 
-	flag.StringVar(&pgConnInfo.user, "U", "pgsql", "PG数据库用户名")
-	flag.StringVar(&pgConnInfo.password, "A", "pgsql", "PG数据库密码")
-	flag.IntVar(&pgConnInfo.port, "P", 31583, "PG数据库端口(31583)")
-	flag.StringVar(&pgConnInfo.host, "H", "152.9.11.99", "PG数据库服务IP")
+//解析命令行参数, 优先级: 配置文件 < 环境变量 < 命令行参数
+func parseArgs(args []string) {
+	def := config.Default()
+	var flags config.Profile
+	flag.StringVar(&flags.Vertica.User, "u", def.Vertica.User, "MPP数据库用户名")
+	flag.StringVar(&flags.Vertica.Password, "a", def.Vertica.Password, "MPP数据库密码")
+	flag.IntVar(&flags.Vertica.Port, "p", def.Vertica.Port, "MPP数据库端口")
+	flag.StringVar(&flags.Vertica.Host, "h", def.Vertica.Host, "MPP数据库服务IP")
+
+	flag.StringVar(&flags.PG.User, "U", def.PG.User, "PG数据库用户名")
+	flag.StringVar(&flags.PG.Password, "A", def.PG.Password, "PG数据库密码")
+	flag.IntVar(&flags.PG.Port, "P", def.PG.Port, "PG数据库端口")
+	flag.StringVar(&flags.PG.Host, "H", def.PG.Host, "PG数据库服务IP")
+
+	flag.StringVar(&flags.Date, "t", "", "要分析的人体聚档任务时间(yyyy-MM-dd), 默认为前一天")
+	flag.StringVar(&flags.TimeZone, "tz", def.TimeZone, "计算任务创建日期的时区, 在线检索和离线快照使用同一时区")
+	flag.StringVar(&flags.S3Root, "s", def.S3Root, "S3根目录")
+	flag.StringVar(&flags.S3.Endpoint, "s3-endpoint", "", "S3服务地址, 如http://152.9.11.99:9000, 设置后通过S3协议读取任务档案, 密钥通过"+config.EnvPrefix+"S3_ACCESS_KEY/"+config.EnvPrefix+"S3_SECRET_KEY设置")
+	flag.StringVar(&flags.S3.Bucket, "s3-bucket", "", "S3存储桶")
+	flag.StringVar(&flags.S3.Prefix, "s3-prefix", "", "任务目录在存储桶中的前缀")
+	flag.StringVar(&flags.DataDir, "d", def.DataDir, "要分析数据所在目录")
+	flag.IntVar(&flags.Query.BatchSize, "batch", def.Query.BatchSize, "每次数据库检索in子句中的最大id数量")
+	flag.IntVar(&flags.Query.BatchParallel, "batch-parallel", def.Query.BatchParallel, "同一次检索中并发执行的批次数量")
+	flag.DurationVar(&flags.Query.Timeout, "timeout", def.Query.Timeout, "整体运行超时时间, 0表示不限制")
+	flag.DurationVar(&flags.Query.QueryTimeout, "query-timeout", def.Query.QueryTimeout, "单次数据库检索超时时间, 0表示不限制")
+	flag.IntVar(&flags.Thresholds.MinPersonWidth, "min-width", def.Thresholds.MinPersonWidth, "人体抓拍最小宽度")
+	flag.IntVar(&flags.Thresholds.MinPersonHeight, "min-height", def.Thresholds.MinPersonHeight, "人体抓拍最小高度")
+	flag.StringVar(&flags.Output.Dir, "out-dir", def.Output.Dir, "结果输出目录, 默认为数据目录下的result目录")
+	flag.StringVar(&flags.Output.Table, "table", def.Output.Table, "逐条抓拍结果表格式, csv, tsv或none")
+	flag.StringVar(&flags.Output.Format, "format", def.Output.Format, "结果文件格式, text, json或html, 逗号分隔同时输出多种格式, json格式见result.schema.json")
+	flag.BoolVar(&flags.Output.Thumbnails, "thumbnails", def.Output.Thumbnails, "html报告中显示抓拍缩略图, 图片通过ImageUrl加载")
+	flag.BoolVar(&flags.Output.Xlsx, "xlsx", def.Output.Xlsx, "同时输出本次运行所有文件结果的Excel工作簿")
+	flag.StringVar(&flags.Output.ImageBaseUrl, "image-base", def.Output.ImageBaseUrl, "图片地址前缀, 数据库中的图片地址为相对路径时使用")
+	flag.IntVar(&flags.Workers, "workers", def.Workers, "同时分析的文件数量")
+	flag.DurationVar(&flags.Progress, "progress", def.Progress, "输出分析进度的间隔, 0表示不输出")
+	flag.Func("precedence", "档案类别优先级, 逗号分隔, 默认为"+strings.Join(def.Precedence, ","), func(v string) error {
+		flags.Precedence = config.SplitList(v)
+		return nil
+	})
+	flag.BoolVar(&flags.StrictArchive, "strict-archive", def.StrictArchive, "档案文件无法读取或解析时终止分析, 默认记录到结果中继续")
+	flag.StringVar(&offline, "offline", "", "离线快照目录或export导出的快照包(目录或.tar.gz), 设置后从导出的csv/json文件读取数据而不连接数据库, "+
+		"快照包中的ids和s3目录在未指定-d及-s/-s3-endpoint时作为数据目录和S3根目录, 即等同于-offline B -d B/ids -s B/s3, "+
+		"未指定-t时分析日期取快照包导出时的日期")
+	flag.StringVar(&configPath, "c", os.Getenv(config.EnvPrefix+"CONFIG"), "配置文件路径")
+	flag.StringVar(&profile, "profile", os.Getenv(config.EnvPrefix+"PROFILE"), "使用的配置名, 为空时使用配置文件中的默认配置")
+	flag.StringVar(&output, "o", "snapshot", "export命令输出的快照包, 以.tar.gz结尾时输出压缩包, 否则输出目录")
+
+	flag.CommandLine.Parse(args)
+
+	var err error
+	cfg, err = config.Load(configPath, profile)
+	if err != nil {
+		log.Fatalln("load config err: ", err)
+	}
+	if err := cfg.ApplyEnv(os.Getenv); err != nil {
+		log.Fatalln("load config err: ", err)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "a" || f.Name == "A" {
+			log.Printf("warning: -%s exposes the password to other users via ps, "+
+				"use %sVERTICA_PASSWORD/%sPG_PASSWORD, passwordFile or ~/.pgpass instead\n", f.Name, config.EnvPrefix, config.EnvPrefix)
+		}
+		if apply, ok := flagFields[f.Name]; ok {
+			apply(&cfg, flags)
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		log.Fatalln("load config err: ", err)
+	}
+	if cfg.Date == "" {
+		cfg.Date = time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	if cfg.Output.Dir == "" {
+		cfg.Output.Dir = filepath.Join(cfg.DataDir, "result")
+	}
+	date, store, dir = cfg.Date, cfg.Storage(), cfg.DataDir
+}
 
-	flag.StringVar(&date, "t", "", "要分析的人体聚档任务时间(yyyy-MM-dd)")
-	flag.StringVar(&root, "s", "/home/minio/data/pvid/person", "S3根目录")
-	flag.StringVar(&dir, "d", "data", "要分析数据所在目录")
+//stdin 为终端时从终端读取密码
+func terminalPrompt() config.Prompt {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil
+	}
+	return func(label string) (string, error) {
+		fmt.Fprint(os.Stderr, label)
+		bs, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(bs), err
+	}
+}
 
-	flag.Parse()
+//连接 MPP 及 PG 数据库并校验配置的表结构, 失败时退出
+func connect(ctx context.Context) (*db.DB, *db.DB) {
+	if err := cfg.ResolvePasswords(terminalPrompt()); err != nil {
+		log.Fatalln("read password err: ", err)
+	}
+	vconn := cfg.VerticaDSN()
+	log.Println("vertica connection info: ", config.RedactDSN(vconn))
+	pconn := cfg.PGDSN()
+	log.Println("pg connection info: ", config.RedactDSN(pconn))
+	conn, err := db.Connect(ctx, db.Vertica, vconn)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	pg, err := db.Connect(ctx, db.PG, pconn)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, d := range []*db.DB{conn, pg} {
+		d.BatchSize = cfg.Query.BatchSize
+		d.Parallel = cfg.Query.BatchParallel
+		d.QueryTimeout = cfg.Query.QueryTimeout
+		d.Schema = cfg.Tables
+		d.TimeZone = cfg.TimeZone
+	}
+	cfg.Vertica.Pool.Apply(conn.DB)
+	cfg.PG.Pool.Apply(pg.DB)
+	if err := conn.ValidateSchema(ctx, db.SnapTables); err != nil {
+		log.Fatalln("validate vertica schema err: ", err)
+	}
+	if err := pg.ValidateSchema(ctx, db.ArchiveTables); err != nil {
+		log.Fatalln("validate pg schema err: ", err)
+	}
+	return conn, pg
+}
 
-	if date == "" {
-		date = time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+//子命令: 默认分析, export 导出离线快照包, config show 输出生效的配置
+func main() {
+	command := "analyze"
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "export" {
+		command = args[0]
+		args = args[1:]
+	} else if len(args) > 1 && args[0] == "config" && args[1] == "show" {
+		command = "config show"
+		args = args[2:]
 	}
+	parseArgs(args)
+	if command == "config show" {
+		bs, err := cfg.Show()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		os.Stdout.Write(bs)
+		return
+	}
+	os.Exit(execute(command))
+}
 
-	vconn = fmt.Sprintf("vertica://%s:%s@%s:%d/viid?sslmode=disable",
-		verticaConnInfo.user, verticaConnInfo.password, verticaConnInfo.host, verticaConnInfo.port)
-	log.Println("vertica conntion info: ", vconn)
-	pconn = fmt.Sprintf("postgres://%s:%s@%s:%d/pvid?sslmode=disable",
-		pgConnInfo.user, pgConnInfo.password, pgConnInfo.host, pgConnInfo.port)
-	log.Println("pg connection info: ", pconn)
+func execute(command string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.Query.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Query.Timeout)
+		defer cancel()
+	}
+	var code int
+	if command == "export" {
+		code = export(ctx)
+	} else {
+		code = run(ctx)
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		log.Println("timeout, stop ", command)
+		return exitTimeout
+	case context.Canceled:
+		log.Println("interrupted, stop ", command)
+		return exitInterrupted
+	}
+	return code
 }
 
-func main() {
-	parseArgs()
-	conn := db.Connect(db.Vertica, vconn)
-	defer conn.Close()
+func run(ctx context.Context) int {
+	var repo db.SnapRepository
+	var archives db.ArchiveRepository
+	if offline != "" {
+		snapshot, cleanup, err := openSnapshot(offline)
+		if err != nil {
+			log.Fatalln("load snapshot err: ", err)
+		}
+		defer cleanup()
+		m, err := db.LoadSnapshot(snapshot, cfg.Tables)
+		if err != nil {
+			log.Fatalln("load snapshot err: ", err)
+		}
+		log.Println("run offline with snapshot: ", offline)
+		useBundleInputs(snapshot, m)
+		m.Location, _ = cfg.Location()
+		repo, archives = m, m
+	} else {
+		conn, pg := connect(ctx)
+		defer conn.Close()
+		defer pg.Close()
+		repo, archives = conn, db.NewCachedArchive(pg)
+	}
 	is, err := file.ReadDir(dir)
 	if err != nil {
 		log.Fatalln("read dir err: ", dir)
 	}
-	for _, i := range is {
-		ar := analyze(conn, i)
-		resultPath := filepath.Join(dir, "result")
-		os.MkdirAll(resultPath, 0777)
-		os.Create(filepath.Join(resultPath, ar.Name))
-		f, err := os.OpenFile(filepath.Join(resultPath, ar.Name), os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
+	//按输入顺序写入结果, 分析并发执行
+	results := make([]AnalyzeResult, len(is))
+	done := make([]chan struct{}, len(is))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	p := newProgress(len(is))
+	reportCtx, stopReport := context.WithCancel(ctx)
+	defer stopReport()
+	go p.report(reportCtx, cfg.Progress)
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	opts := newAnalyzeOptions()
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				p.begin()
+				results[i] = analyze(ctx, repo, archives, opts, is[i])
+				p.finish()
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range is {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	failed := 0
+	var written []AnalyzeResult
+	reporters := outputReporters()
+	resultPath := cfg.Output.Dir
+	os.MkdirAll(resultPath, 0777)
+	for i := range is {
+		select {
+		case <-done[i]:
+		case <-ctx.Done():
+			//中断后仍写入已完成的文件, 只跳过未完成的
+			select {
+			case <-done[i]:
+			default:
+				continue
+			}
+		}
+		ar := results[i]
+		if ctx.Err() != nil && ar.Err != nil {
+			//中断后出错的文件结果可能不完整, 驱动返回的错误不一定包装 context 错误, 不写入
+			continue
+		}
+		if ar.Err != nil {
+			failed++
+		}
+		if err := writeReports(resultPath, reporters, ar); err != nil {
 			log.Fatalln(err)
 		}
-		ar.Write(f)
+		written = append(written, ar)
+	}
+	if cfg.Output.Xlsx {
+		if err := writeWorkbook(resultPath, written); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	stopReport()
+	p.print()
+	if failed > 0 {
+		log.Printf("%d of %d files failed to analyze\n", failed, len(is))
+		return exitFailed
 	}
+	return 0
 }
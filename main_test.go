@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"dytest/config"
+	"dytest/db"
+	"dytest/file"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const idPrefix = "0010123456789123456789123456789123456789"
+
+func personId(n int) string {
+	return fmt.Sprintf("%s001%05d", idPrefix, n)
+}
+
+func faceId(n int) string {
+	return fmt.Sprintf("%s006%05d", idPrefix, n)
+}
+
+//在临时目录下写入任务的档案文件
+func writeArchives(t *testing.T, root string, task string, archives map[string]string) {
+	t.Helper()
+	dir := filepath.Join(root, task, "Archive")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range archives {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func analyzeFixture(t *testing.T, precedence []string) AnalyzeResult {
+	t.Helper()
+	m, err := db.LoadFixture("testdata/analyze.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	writeArchives(t, root, "task1", map[string]string{
+		file.SingleArchiveFile: `["` + personId(5) + `"]`,
+		file.BigArchiveFile:    `[{"deviceNum": 1, "archiveNum": 2, "devices": ["dev1"], "archive": ["` + personId(4) + `", "` + personId(5) + `"]}]`,
+		file.RawArchiveFile:    `[{"archiveId": "r1", "personIds": ["` + personId(7) + `"]}]`,
+	})
+	//其他日期的任务不参与分析
+	writeArchives(t, root, "task0", map[string]string{
+		file.BigArchiveFile: `[{"deviceNum": 1, "archiveNum": 1, "devices": ["dev1"], "archive": ["` + personId(6) + `"]}]`,
+	})
+	opts := analyzeOptions{
+		Date:       "2023-04-15",
+		Store:      file.LocalStorage{Root: root},
+		Thresholds: config.Thresholds{MinPersonWidth: 60, MinPersonHeight: 150},
+		Precedence: precedence,
+	}
+	idStruct := file.IdStruct{Name: "walk", FaceIds: []string{faceId(1), faceId(2)}}
+	for i := 1; i <= 7; i++ {
+		idStruct.PersonIds = append(idStruct.PersonIds, personId(i))
+	}
+	r := analyze(context.Background(), m, m, opts, idStruct)
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	return r
+}
+
+func discardReasons(r AnalyzeResult) map[string]string {
+	reasons := make(map[string]string)
+	for _, d := range r.PersonDiscard {
+		reasons[d.Id] = d.DiscardReason
+	}
+	return reasons
+}
+
+func TestAnalyze(t *testing.T) {
+	r := analyzeFixture(t, file.DefaultPrecedence())
+
+	if len(r.PeopleInfos) != 1 {
+		t.Fatalf("people infos = %v, want one people", r.PeopleInfos)
+	}
+	p := r.PeopleInfos[0]
+	if p.PeopleId != "peo1" || !reflect.DeepEqual(p.PersonTracks, []string{personId(1)}) ||
+		!reflect.DeepEqual(p.FaceTracks, []string{faceId(1)}) {
+		t.Errorf("people info = %+v", p)
+	}
+
+	want := []FaceDiscard{{DiscardReason: "质量低", Ids: []string{faceId(2)}}}
+	if !reflect.DeepEqual(r.FaceDiscards, want) {
+		t.Errorf("face discards = %+v, want %+v", r.FaceDiscards, want)
+	}
+
+	wantReasons := map[string]string{
+		personId(2): file.SmallSize,
+		personId(3): file.DeviceNotArchived,
+		personId(4): file.BigArchiveTrash,
+		personId(5): file.SingleArchiveTrash,
+		personId(6): "",
+		personId(7): file.NoLinkArchiveTrash,
+	}
+	if got := discardReasons(r); !reflect.DeepEqual(got, wantReasons) {
+		t.Errorf("person discard reasons = %v, want %v", got, wantReasons)
+	}
+	for _, d := range r.PersonDiscard {
+		if d.Id != personId(5) {
+			continue
+		}
+		var categories []string
+		for _, h := range d.Hits {
+			categories = append(categories, h.Category)
+		}
+		sort.Strings(categories)
+		if !reflect.DeepEqual(categories, []string{file.BigArchiveFile, file.SingleArchiveFile}) || d.WorkTask != "task1" {
+			t.Errorf("person discard = %+v, want hits in both archives of task1", d)
+		}
+	}
+}
+
+func TestAnalyzePrecedence(t *testing.T) {
+	r := analyzeFixture(t, []string{file.BigArchiveFile, file.SingleArchiveFile})
+	if got := discardReasons(r)[personId(5)]; got != file.BigArchiveTrash {
+		t.Errorf("discard reason = %q, want %q", got, file.BigArchiveTrash)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+//单条输入抓拍的分析结果
+type SnapOutcome struct {
+	Type            string
+	SnapId          string
+	DeviceId        string
+	PeopleId        string
+	DiscardReason   string
+	WorkTask        string
+	ArchiveCategory string
+}
+
+var outcomeHeader = []string{"type", "snap_id", "device_id", "people_id", "discard_reason", "work_task", "archive_category"}
+
+func (o SnapOutcome) record() []string {
+	return []string{o.Type, o.SnapId, o.DeviceId, o.PeopleId, o.DiscardReason, o.WorkTask, o.ArchiveCategory}
+}
+
+//按输入顺序列出每条人脸及人体抓拍的结果, 先人脸后人体
+func (r AnalyzeResult) SnapOutcomes() []SnapOutcome {
+	peopleOf := make(map[string]string)
+	for _, p := range r.PeopleInfos {
+		for _, id := range p.FaceTracks {
+			peopleOf[id] = p.PeopleId
+		}
+		for _, id := range p.PersonTracks {
+			peopleOf[id] = p.PeopleId
+		}
+	}
+	faceReasons := make(map[string]string)
+	for _, f := range r.FaceDiscards {
+		for _, id := range f.Ids {
+			faceReasons[id] = f.DiscardReason
+		}
+	}
+	personDiscards := make(map[string]PersonDiscard)
+	for _, p := range r.PersonDiscard {
+		personDiscards[p.Id] = p
+	}
+
+	outcomes := make([]SnapOutcome, 0, len(r.FaceIds)+len(r.PersonIds))
+	for _, id := range r.FaceIds {
+		outcomes = append(outcomes, SnapOutcome{
+			Type:          "face",
+			SnapId:        id,
+			DeviceId:      r.SnapDevices[id],
+			PeopleId:      peopleOf[id],
+			DiscardReason: faceReasons[id],
+		})
+	}
+	for _, id := range r.PersonIds {
+		o := SnapOutcome{Type: "person", SnapId: id, DeviceId: r.SnapDevices[id], PeopleId: peopleOf[id]}
+		if d, ok := personDiscards[id]; ok {
+			o.DiscardReason = d.DiscardReason
+			o.WorkTask = d.WorkTask
+			if d.PersonArchiveInfo != nil {
+				o.ArchiveCategory = d.PersonArchiveInfo.Category
+			}
+		}
+		outcomes = append(outcomes, o)
+	}
+	return outcomes
+}
+
+//写入逐条抓拍结果表, 以 comma 分隔各列
+func (r AnalyzeResult) WriteOutcomes(w io.Writer, comma rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	if err := cw.Write(outcomeHeader); err != nil {
+		return err
+	}
+	for _, o := range r.SnapOutcomes() {
+		if err := cw.Write(o.record()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"dytest/db"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+//分析进度
+type progress struct {
+	total   int
+	done    int64
+	running int64
+	start   time.Time
+}
+
+func newProgress(total int) *progress {
+	return &progress{total: total, start: time.Now()}
+}
+
+func (p *progress) begin() {
+	atomic.AddInt64(&p.running, 1)
+}
+
+func (p *progress) finish() {
+	atomic.AddInt64(&p.running, -1)
+	atomic.AddInt64(&p.done, 1)
+}
+
+func (p *progress) print() {
+	log.Printf("progress: files %d/%d done, %d running, queries in flight: %d, elapsed: %v\n",
+		atomic.LoadInt64(&p.done), p.total, atomic.LoadInt64(&p.running), db.InFlight(),
+		time.Since(p.start).Round(time.Second))
+}
+
+//每隔 interval 输出一次进度, 直到 ctx 结束
+func (p *progress) report(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.print()
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"dytest/config"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//出错后不再写入, 由调用方在结束时检查 err
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) print(s string) {
+	if e.err == nil {
+		_, e.err = io.WriteString(e.w, s)
+	}
+}
+
+func (e *errWriter) printf(format string, args ...interface{}) {
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
+}
+
+//以一种格式输出单个文件的分析结果
+type Reporter interface {
+	//结果文件名在 id 文件名后追加的后缀, 如 .json
+	Ext() string
+	Report(w io.Writer, r AnalyzeResult) error
+}
+
+type textReporter struct{}
+
+func (textReporter) Ext() string { return "" }
+
+func (textReporter) Report(w io.Writer, r AnalyzeResult) error { return r.Write(w) }
+
+type jsonReporter struct{}
+
+func (jsonReporter) Ext() string { return ".json" }
+
+func (jsonReporter) Report(w io.Writer, r AnalyzeResult) error { return r.WriteJSON(w) }
+
+type htmlReporter struct{}
+
+func (htmlReporter) Ext() string { return ".html" }
+
+func (htmlReporter) Report(w io.Writer, r AnalyzeResult) error { return r.WriteHTML(w) }
+
+//逐条抓拍结果表, 带 BOM 以便表格软件识别 UTF-8
+type csvReporter struct {
+	ext   string
+	comma rune
+}
+
+func (c csvReporter) Ext() string { return c.ext }
+
+func (c csvReporter) Report(w io.Writer, r AnalyzeResult) error {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	return r.WriteOutcomes(w, c.comma)
+}
+
+var reporters = map[string]Reporter{
+	config.FormatText: textReporter{},
+	config.FormatJSON: jsonReporter{},
+	config.FormatHTML: htmlReporter{},
+	config.TableCSV:   csvReporter{ext: ".snaps.csv", comma: ','},
+	config.TableTSV:   csvReporter{ext: ".snaps.tsv", comma: '\t'},
+}
+
+//配置的结果格式及逐条抓拍结果表, 格式已由 config.Validate 校验
+func outputReporters() []Reporter {
+	var rs []Reporter
+	for _, f := range strings.Split(cfg.Output.Format, ",") {
+		rs = append(rs, reporters[strings.TrimSpace(f)])
+	}
+	if cfg.Output.Table != config.TableNone {
+		rs = append(rs, reporters[cfg.Output.Table])
+	}
+	return rs
+}
+
+//按各格式写入 resultPath 下以 id 文件名加格式后缀命名的文件
+func writeReports(resultPath string, rs []Reporter, r AnalyzeResult) error {
+	log.Println("start to write result to file: ", r.Name)
+	for _, reporter := range rs {
+		f, err := os.Create(filepath.Join(resultPath, r.Name+reporter.Ext()))
+		if err != nil {
+			return err
+		}
+		err = reporter.Report(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("write result %s%s: %w", r.Name, reporter.Ext(), err)
+		}
+	}
+	log.Println("end write result: ", r.Name)
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+//json 结果的格式版本, 字段说明见 result.schema.json, 删除或修改已有字段时递增
+const ResultSchemaVersion = 1
+
+func (r AnalyzeResult) MarshalJSON() ([]byte, error) {
+	type plain AnalyzeResult
+	var errMsg string
+	if r.Err != nil {
+		errMsg = r.Err.Error()
+	}
+	return json.Marshal(struct {
+		SchemaVersion int `json:"schemaVersion"`
+		plain
+		Error string `json:"error,omitempty"`
+	}{ResultSchemaVersion, plain(r), errMsg})
+}
+
+func (r AnalyzeResult) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
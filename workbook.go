@@ -0,0 +1,80 @@
+package main
+
+import (
+	"dytest/utils"
+	"dytest/xlsx"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+//本次运行所有文件的结果, 每个 id 一行
+func newWorkbook(results []AnalyzeResult) *xlsx.Workbook {
+	wb := xlsx.NewWorkbook()
+	summary := wb.AddSheet("概况")
+	summary.AddRow("文件", "设备数", "人脸设备数", "人体设备数", "人脸抓拍数", "人体抓拍数",
+		"召回设备数", "档案数", "人脸丢弃数", "人体丢弃数", "无效ID数", "错误")
+	peoples := wb.AddSheet("档案")
+	peoples.AddRow("文件", "档案ID", "抓拍类型", "抓拍ID", "设备ID")
+	faces := wb.AddSheet("人脸丢弃")
+	faces.AddRow("文件", "丢弃原因", "抓拍ID", "设备ID")
+	persons := wb.AddSheet("人体丢弃")
+	persons.AddRow("文件", "抓拍ID", "设备ID", "丢弃原因", "任务", "档案类别")
+	invalids := wb.AddSheet("无效ID")
+	invalids.AddRow("文件", "ID")
+
+	for _, r := range results {
+		faceDiscards := 0
+		for _, f := range r.FaceDiscards {
+			faceDiscards += len(f.Ids)
+			for _, id := range f.Ids {
+				faces.AddRow(r.Name, f.DiscardReason, id, r.SnapDevices[id])
+			}
+		}
+		var errMsg string
+		if r.Err != nil {
+			errMsg = r.Err.Error()
+		}
+		summary.AddRow(r.Name,
+			len(utils.RemoveDeplicated(append(append([]string{}, r.SnapInfo.FaceDevices...), r.SnapInfo.PersonDevices...))),
+			len(r.SnapInfo.FaceDevices), len(r.SnapInfo.PersonDevices),
+			r.SnapInfo.FaceSnapNum, r.SnapInfo.PersonSnapNum,
+			len(r.DeviceIds), len(r.PeopleInfos), faceDiscards, len(r.PersonDiscard), len(r.InvalidIds), errMsg)
+		for _, p := range r.PeopleInfos {
+			for _, id := range p.FaceTracks {
+				peoples.AddRow(r.Name, p.PeopleId, "人脸", id, r.SnapDevices[id])
+			}
+			for _, id := range p.PersonTracks {
+				peoples.AddRow(r.Name, p.PeopleId, "人体", id, r.SnapDevices[id])
+			}
+		}
+		for _, p := range r.PersonDiscard {
+			var category string
+			if p.PersonArchiveInfo != nil {
+				category = p.PersonArchiveInfo.Category
+			}
+			persons.AddRow(r.Name, p.Id, p.DeviceId, p.DiscardReason, p.WorkTask, category)
+		}
+		for _, id := range r.InvalidIds {
+			invalids.AddRow(r.Name, id)
+		}
+	}
+	return wb
+}
+
+//工作簿输出为结果目录下的 result-<date>.xlsx
+func writeWorkbook(resultPath string, results []AnalyzeResult) error {
+	name := filepath.Join(resultPath, "result-"+date+".xlsx")
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = newWorkbook(results).Write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		log.Println("write workbook: ", name)
+	}
+	return err
+}